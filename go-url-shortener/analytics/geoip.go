@@ -0,0 +1,49 @@
+package analytics
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoResolver maps a visitor IP to an ISO 3166-1 country code, "" when unknown.
+type GeoResolver interface {
+	Country(ip net.IP) string
+	Close() error
+}
+
+type noGeo struct{}
+
+func (noGeo) Country(net.IP) string { return "" }
+
+func (noGeo) Close() error { return nil }
+
+// MaxMind resolves countries from an offline GeoLite2/GeoIP2 country (or city)
+// database file.
+type MaxMind struct {
+	reader *maxminddb.Reader
+}
+
+func OpenMaxMind(path string) (*MaxMind, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &MaxMind{reader: reader}, nil
+}
+
+func (m *MaxMind) Country(ip net.IP) string {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := m.reader.Lookup(ip, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+func (m *MaxMind) Close() error {
+	return m.reader.Close()
+}
@@ -0,0 +1,215 @@
+package analytics
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-url-shortener/logging"
+	"go-url-shortener/store"
+)
+
+// Click is what the redirect handler hands over. Enriching it into a
+// store.ClickEvent (country lookup, IP hashing) happens on the workers so the
+// redirect itself never waits for it.
+type Click struct {
+	ShortUrl  string
+	Timestamp time.Time
+	Referrer  string
+	UserAgent string
+	ClientIP  string
+	// RequestID is the request the click was made in, for the logs.
+	RequestID string
+}
+
+// Sink receives the enriched events; every store.Store is one.
+type Sink interface {
+	RecordClick(ctx context.Context, event *store.ClickEvent) error
+}
+
+type Options struct {
+	// BufferSize is how many clicks may wait for a worker before new ones are
+	// dropped.
+	BufferSize int `yaml:"buffer_size"`
+	Workers    int `yaml:"workers"`
+	// GeoIPPath points to a MaxMind country database, empty disables the
+	// country lookup.
+	GeoIPPath string `yaml:"geoip_db"`
+	// IPHashSalt is mixed into the visitor IP hashes. Left empty a random salt
+	// is generated, so unique visitors are only counted per process lifetime.
+	IPHashSalt string `yaml:"ip_hash_salt"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		BufferSize: 1024,
+		Workers:    2,
+	}
+}
+
+var ErrClosed = errors.New("click recorder closed")
+
+// Recorder is the buffered click pipeline between the redirect handler and
+// the store.
+type Recorder struct {
+	sink   Sink
+	geo    GeoResolver
+	salt   []byte
+	clicks chan Click
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+
+	dropped atomic.Int64
+}
+
+func NewRecorder(sink Sink, opts Options) (*Recorder, error) {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultOptions().BufferSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultOptions().Workers
+	}
+
+	salt := []byte(opts.IPHashSalt)
+	if len(salt) == 0 {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	}
+
+	var geo GeoResolver = noGeo{}
+	if opts.GeoIPPath != "" {
+		maxMind, err := OpenMaxMind(opts.GeoIPPath)
+		if err != nil {
+			return nil, err
+		}
+		geo = maxMind
+	}
+
+	r := &Recorder{
+		sink:   sink,
+		geo:    geo,
+		salt:   salt,
+		clicks: make(chan Click, opts.BufferSize),
+	}
+	for i := 0; i < opts.Workers; i++ {
+		r.wg.Add(1)
+		go r.work()
+	}
+	return r, nil
+}
+
+// Record queues click without blocking. It reports false when the buffer is
+// full or the recorder closed, in which case the click is dropped.
+func (r *Recorder) Record(click Click) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		r.dropped.Add(1)
+		return false
+	}
+	select {
+	case r.clicks <- click:
+		return true
+	default:
+		r.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped is the number of clicks lost to a full buffer or a closed recorder.
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Close stops accepting clicks and returns once the queued ones are written.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return ErrClosed
+	}
+	r.closed = true
+	close(r.clicks)
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	return r.geo.Close()
+}
+
+func (r *Recorder) work() {
+	defer r.wg.Done()
+	for click := range r.clicks {
+		// Clicks outlive the requests they were made in, only their id is kept.
+		ctx := logging.WithRequestID(context.Background(), click.RequestID)
+		event := r.enrich(click)
+		if err := r.sink.RecordClick(ctx, event); err != nil {
+			slog.WarnContext(ctx, "recording a click failed", "code", click.ShortUrl, "error", err)
+		}
+	}
+}
+
+func (r *Recorder) enrich(click Click) *store.ClickEvent {
+	event := &store.ClickEvent{
+		ShortUrl:  click.ShortUrl,
+		Timestamp: click.Timestamp,
+		Referrer:  referrerHost(click.Referrer),
+		UserAgent: click.UserAgent,
+	}
+	if ip := net.ParseIP(click.ClientIP); ip != nil {
+		event.Country = r.geo.Country(ip)
+		event.IPHash = r.hashIP(ip)
+	}
+	return event
+}
+
+func (r *Recorder) hashIP(ip net.IP) string {
+	h := sha256.New()
+	h.Write(r.salt)
+	h.Write(ip)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// referrerHost keeps only the host of the Referer header, which is all the
+// statistics group by.
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return ""
+	}
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+var defaultRecorder *Recorder
+
+// InitializeRecorder starts the recorder used by RecordClick.
+func InitializeRecorder(sink Sink, opts Options) (*Recorder, error) {
+	r, err := NewRecorder(sink, opts)
+	if err != nil {
+		return nil, err
+	}
+	defaultRecorder = r
+	return r, nil
+}
+
+// RecordClick queues click on the recorder started by InitializeRecorder and
+// is a no-op when analytics are not running.
+func RecordClick(click Click) {
+	if defaultRecorder != nil {
+		defaultRecorder.Record(click)
+	}
+}
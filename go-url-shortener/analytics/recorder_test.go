@@ -0,0 +1,97 @@
+package analytics
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/store"
+)
+
+func TestRecorderWritesEnrichedClicks(t *testing.T) {
+	s := store.NewMemoryStore()
+	r, err := NewRecorder(s, Options{IPHashSalt: "pepper"})
+	require.NoError(t, err)
+
+	now := time.Now()
+	clicks := []Click{
+		{ShortUrl: "abc", Timestamp: now, ClientIP: "203.0.113.7", Referrer: "https://news.example.org/story?id=1", UserAgent: "curl/8.0"},
+		{ShortUrl: "abc", Timestamp: now, ClientIP: "203.0.113.7"},
+		{ShortUrl: "abc", Timestamp: now, ClientIP: "198.51.100.1"},
+	}
+	for _, click := range clicks {
+		assert.True(t, r.Record(click))
+	}
+	require.NoError(t, r.Close())
+
+	stats, err := s.ClickStats(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Total)
+	assert.Equal(t, int64(2), stats.UniqueVisitors)
+	assert.Equal(t, map[string]int64{"news.example.org": 1}, stats.Referrers)
+
+	for _, event := range stats.Recent {
+		assert.NotContains(t, event.IPHash, "203.0.113.7")
+		assert.Len(t, event.IPHash, 32)
+	}
+}
+
+func TestRecorderHashIsSalted(t *testing.T) {
+	a, err := NewRecorder(store.NewMemoryStore(), Options{IPHashSalt: "one"})
+	require.NoError(t, err)
+	defer a.Close()
+	b, err := NewRecorder(store.NewMemoryStore(), Options{IPHashSalt: "two"})
+	require.NoError(t, err)
+	defer b.Close()
+
+	click := Click{ShortUrl: "abc", ClientIP: "203.0.113.7"}
+	assert.Equal(t, a.enrich(click).IPHash, a.enrich(click).IPHash)
+	assert.NotEqual(t, a.enrich(click).IPHash, b.enrich(click).IPHash)
+}
+
+// blockingSink holds every write until release is closed.
+type blockingSink struct {
+	release chan struct{}
+	mu      sync.Mutex
+	events  int
+}
+
+func (s *blockingSink) RecordClick(context.Context, *store.ClickEvent) error {
+	<-s.release
+	s.mu.Lock()
+	s.events++
+	s.mu.Unlock()
+	return nil
+}
+
+func TestRecorderDropsWhenFull(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	r, err := NewRecorder(sink, Options{BufferSize: 2, Workers: 1})
+	require.NoError(t, err)
+
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		if r.Record(Click{ShortUrl: "abc"}) {
+			accepted++
+		}
+	}
+	// One click is held by the worker, two wait in the buffer.
+	assert.LessOrEqual(t, accepted, 3)
+	assert.Equal(t, int64(10-accepted), r.Dropped())
+
+	close(sink.release)
+	require.NoError(t, r.Close())
+	assert.Equal(t, accepted, sink.events)
+
+	assert.False(t, r.Record(Click{ShortUrl: "abc"}))
+	assert.ErrorIs(t, r.Close(), ErrClosed)
+}
+
+func TestReferrerHost(t *testing.T) {
+	assert.Equal(t, "example.com", referrerHost("https://example.com/path?q=1"))
+	assert.Equal(t, "", referrerHost(""))
+	assert.Equal(t, "", referrerHost("::not a url"))
+}
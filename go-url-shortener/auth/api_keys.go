@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"go-url-shortener/store"
+)
+
+// Keys look like "sk_<id>_<secret>". The id is public and locates the stored
+// key, only the sha256 of the whole key is ever persisted.
+const (
+	KeyPrefix   = "sk_"
+	idBytes     = 8
+	secretBytes = 32
+)
+
+var ErrInvalidKey = errors.New("invalid api key")
+
+func hashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Mint issues a new key for userId. The returned plaintext cannot be
+// recovered later.
+func Mint(ctx context.Context, userId string) (string, *store.ApiKey, error) {
+	if userId == "" {
+		return "", nil, errors.New("api key needs a user id")
+	}
+	id, err := randomHex(idBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(secretBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	plaintext := KeyPrefix + id + "_" + secret
+	key := &store.ApiKey{
+		Id:        id,
+		Hash:      hashKey(plaintext),
+		UserId:    userId,
+		CreatedAt: time.Now(),
+	}
+	if err := store.SaveApiKey(ctx, key); err != nil {
+		return "", nil, err
+	}
+	return plaintext, key, nil
+}
+
+// Verify resolves plaintext to its stored key. Malformed, unknown and revoked
+// keys all fail with ErrInvalidKey; store failures are passed through.
+func Verify(ctx context.Context, plaintext string) (*store.ApiKey, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(plaintext, KeyPrefix), "_")
+	if !strings.HasPrefix(plaintext, KeyPrefix) || !ok || id == "" {
+		return nil, ErrInvalidKey
+	}
+	key, err := store.RetrieveApiKey(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashKey(plaintext))) != 1 {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// Revoke deletes the key with the given id, so it fails verification from
+// then on.
+func Revoke(ctx context.Context, id string) error {
+	return store.DeleteApiKey(ctx, id)
+}
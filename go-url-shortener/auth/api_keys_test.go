@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/store"
+)
+
+func init() {
+	if _, err := store.InitializeStore(store.Options{Backend: store.BackendMemory}); err != nil {
+		panic(err)
+	}
+}
+
+func TestMintAndVerify(t *testing.T) {
+	ctx := context.Background()
+	plaintext, key, err := Mint(ctx, "alice")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(plaintext, KeyPrefix+key.Id+"_"))
+	assert.NotContains(t, key.Hash, plaintext)
+
+	stored, err := store.RetrieveApiKey(ctx, key.Id)
+	require.NoError(t, err)
+	assert.Equal(t, hashKey(plaintext), stored.Hash)
+
+	verified, err := Verify(ctx, plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", verified.UserId)
+}
+
+func TestVerifyRejects(t *testing.T) {
+	ctx := context.Background()
+	plaintext, key, err := Mint(ctx, "bob")
+	require.NoError(t, err)
+
+	for _, bad := range []string{"", "sk_", "nope", plaintext + "x", KeyPrefix + key.Id + "_forged", strings.TrimPrefix(plaintext, KeyPrefix)} {
+		_, err := Verify(ctx, bad)
+		assert.ErrorIs(t, err, ErrInvalidKey, bad)
+	}
+
+	require.NoError(t, Revoke(ctx, key.Id))
+	_, err = Verify(ctx, plaintext)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	assert.ErrorIs(t, Revoke(ctx, key.Id), store.ErrNotFound)
+}
+
+func TestMintNeedsUser(t *testing.T) {
+	_, _, err := Mint(context.Background(), "")
+	assert.Error(t, err)
+}
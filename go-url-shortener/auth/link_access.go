@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-url-shortener/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password protected links only keep the bcrypt hash of their password.
+// Visitors who typed it get an access token signed with a server secret over
+// the code, the password hash and an expiry, so changing the password revokes
+// the tokens already handed out.
+const (
+	MinLinkPasswordLength = 4
+	// MaxLinkPasswordLength is all bcrypt looks at.
+	MaxLinkPasswordLength = 72
+)
+
+var (
+	ErrInvalidPassword    = fmt.Errorf("password must be between %d and %d bytes", MinLinkPasswordLength, MaxLinkPasswordLength)
+	ErrInvalidAccessToken = errors.New("invalid link access token")
+)
+
+// LinkPasswordCost is the bcrypt cost of new link passwords.
+var LinkPasswordCost = bcrypt.DefaultCost
+
+// ValidateLinkPassword checks password can be hashed without hashing it.
+func ValidateLinkPassword(password string) error {
+	if len(password) < MinLinkPasswordLength || len(password) > MaxLinkPasswordLength {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+func HashLinkPassword(password string) (string, error) {
+	if err := ValidateLinkPassword(password); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), LinkPasswordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckLinkPassword reports whether password unlocks record.
+func CheckLinkPassword(record *store.LinkRecord, password string) bool {
+	if record.PasswordHash == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(password)) == nil
+}
+
+func linkAccessMac(secret []byte, record *store.LinkRecord, expires string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(record.ShortUrl + "\x00" + record.PasswordHash + "\x00" + expires))
+	return mac.Sum(nil)
+}
+
+// SignLinkAccess issues a token granting access to record until expiresAt.
+func SignLinkAccess(secret []byte, record *store.LinkRecord, expiresAt time.Time) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expires + "." + base64.RawURLEncoding.EncodeToString(linkAccessMac(secret, record, expires))
+}
+
+// VerifyLinkAccess fails with ErrInvalidAccessToken unless token was signed
+// for record, with its current password, and has not expired at now.
+func VerifyLinkAccess(secret []byte, record *store.LinkRecord, token string, now time.Time) error {
+	expires, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidAccessToken
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() >= expiresAt {
+		return ErrInvalidAccessToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, linkAccessMac(secret, record, expires)) {
+		return ErrInvalidAccessToken
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	LinkPasswordCost = bcrypt.MinCost
+}
+
+func TestLinkPassword(t *testing.T) {
+	hash, err := HashLinkPassword("open sesame")
+	require.NoError(t, err)
+	record := &store.LinkRecord{ShortUrl: "locked", PasswordHash: hash}
+
+	assert.True(t, CheckLinkPassword(record, "open sesame"))
+	assert.False(t, CheckLinkPassword(record, "open sesame!"))
+	assert.False(t, CheckLinkPassword(record, ""))
+	assert.True(t, CheckLinkPassword(&store.LinkRecord{ShortUrl: "open"}, ""))
+
+	_, err = HashLinkPassword("abc")
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+	_, err = HashLinkPassword(strings.Repeat("a", MaxLinkPasswordLength+1))
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+}
+
+func TestLinkAccessToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	record := &store.LinkRecord{ShortUrl: "locked", PasswordHash: "$2a$04$first"}
+	token := SignLinkAccess(secret, record, now.Add(time.Hour))
+
+	assert.NoError(t, VerifyLinkAccess(secret, record, token, now))
+	assert.ErrorIs(t, VerifyLinkAccess(secret, record, token, now.Add(time.Hour)), ErrInvalidAccessToken, "expired")
+	assert.ErrorIs(t, VerifyLinkAccess([]byte("other"), record, token, now), ErrInvalidAccessToken)
+	assert.ErrorIs(t, VerifyLinkAccess(secret, &store.LinkRecord{ShortUrl: "other", PasswordHash: record.PasswordHash}, token, now), ErrInvalidAccessToken)
+	assert.ErrorIs(t, VerifyLinkAccess(secret, &store.LinkRecord{ShortUrl: "locked", PasswordHash: "$2a$04$second"}, token, now), ErrInvalidAccessToken,
+		"a new password revokes the tokens")
+
+	expires, _, _ := strings.Cut(token, ".")
+	for _, forged := range []string{"", "garbage", expires + ".", "9999999999." + strings.SplitN(token, ".", 2)[1]} {
+		assert.ErrorIs(t, VerifyLinkAccess(secret, record, forged, now), ErrInvalidAccessToken, forged)
+	}
+}
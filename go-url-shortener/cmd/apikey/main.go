@@ -0,0 +1,72 @@
+// Command apikey mints and revokes the API keys accepted by the shortener.
+//
+//	apikey [flags] mint <user-id>
+//	apikey [flags] revoke <key-id>
+//
+// It reads the same configuration file, environment and flags as the server
+// to find the store. A bolt database is locked by a running server, so stop it
+// first.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"go-url-shortener/auth"
+	"go-url-shortener/config"
+	"go-url-shortener/store"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] mint <user-id> | revoke <key-id>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration - Error: %v\n", err)
+		os.Exit(2)
+	}
+	storeOptions := cfg.Store
+
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(2)
+	}
+	if storeOptions.Backend == store.BackendMemory {
+		fmt.Fprintln(os.Stderr, "keys minted into the memory backend would be lost on exit")
+		os.Exit(2)
+	}
+
+	s, err := store.InitializeStore(storeOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize the store - Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	switch command, arg := flag.Arg(0), flag.Arg(1); command {
+	case "mint":
+		plaintext, key, err := auth.Mint(ctx, arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to mint the key - Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("id:  %s\nkey: %s\n", key.Id, plaintext)
+		fmt.Fprintln(os.Stderr, "The key is shown only once, store it safely.")
+	case "revoke":
+		if err := auth.Revoke(ctx, arg); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revoke key %s - Error: %v\n", arg, err)
+			os.Exit(1)
+		}
+		fmt.Printf("revoked %s\n", arg)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,427 @@
+// Package config gathers the settings of the shortener from, in increasing
+// order of precedence, built-in defaults, a YAML file, SHORTENER_* environment
+// variables and command line flags.
+package config
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go-url-shortener/analytics"
+	"go-url-shortener/handler"
+	"go-url-shortener/logging"
+	"go-url-shortener/shortener"
+	"go-url-shortener/store"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variable of every flag: -redis-addr is
+// read from SHORTENER_REDIS_ADDR.
+const EnvPrefix = "SHORTENER_"
+
+type Config struct {
+	ListenAddr string `yaml:"listen_addr"`
+	// TrustedProxies lists the addresses and CIDR ranges of the reverse
+	// proxies whose X-Forwarded-For header names the client IP. It is ignored
+	// for every other peer, none being trusted by default.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// ShutdownTimeout bounds how long requests in flight may take to finish
+	// once the server is asked to stop.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// BaseUrl prefixes the short codes handed out to clients.
+	BaseUrl string `yaml:"base_url"`
+
+	// MaxUrlLength caps the long urls accepted; BlocklistPath names a
+	// shortener.FileBlocklist, none when empty.
+	MaxUrlLength  int    `yaml:"max_url_length"`
+	BlocklistPath string `yaml:"blocklist"`
+
+	// CodeStrategy is one of the shortener.Strategy* generators, an empty
+	// CodeAlphabet picks its default alphabet.
+	CodeStrategy string `yaml:"code_strategy"`
+	CodeAlphabet string `yaml:"code_alphabet"`
+	CodeLength   int    `yaml:"code_length"`
+
+	DefaultTTL       time.Duration `yaml:"default_ttl"`
+	ExpiredRetention time.Duration `yaml:"expired_retention"`
+	IdempotencyTTL   time.Duration `yaml:"idempotency_ttl"`
+
+	// PermanentRedirectMaxAge is how long clients may cache 301 and 308
+	// redirects.
+	PermanentRedirectMaxAge time.Duration `yaml:"permanent_redirect_max_age"`
+
+	Store     store.Options     `yaml:"store"`
+	Analytics analytics.Options `yaml:"analytics"`
+	Log       logging.Options   `yaml:"log"`
+
+	BulkWorkers   int `yaml:"bulk_workers"`
+	BulkBatchSize int `yaml:"bulk_batch_size"`
+	MaxBulkItems  int `yaml:"max_bulk_items"`
+
+	CreateRateLimit   store.RateLimit `yaml:"create_rate_limit"`
+	RedirectRateLimit store.RateLimit `yaml:"redirect_rate_limit"`
+	UnlockRateLimit   store.RateLimit `yaml:"unlock_rate_limit"`
+	BulkRateLimit     store.RateLimit `yaml:"bulk_rate_limit"`
+
+	// LinkAccessSecret signs the cookies unlocking password protected links,
+	// random per process when empty. LinkAccessTTL is how long they last.
+	LinkAccessSecret string        `yaml:"link_access_secret"`
+	LinkAccessTTL    time.Duration `yaml:"link_access_ttl"`
+}
+
+// Default takes its values from the package level settings it configures.
+func Default() *Config {
+	return &Config{
+		ListenAddr:              ":9808",
+		ShutdownTimeout:         15 * time.Second,
+		BaseUrl:                 handler.BaseUrl,
+		MaxUrlLength:            handler.TargetPolicy.MaxLength,
+		CodeStrategy:            shortener.StrategyHash,
+		CodeLength:              shortener.ShortLinkLength,
+		DefaultTTL:              store.DefaultTTL,
+		ExpiredRetention:        store.ExpiredRetention,
+		IdempotencyTTL:          store.IdempotencyKeyTTL,
+		PermanentRedirectMaxAge: handler.PermanentRedirectMaxAge,
+		Store:                   store.DefaultOptions(),
+		Analytics:               analytics.DefaultOptions(),
+		Log:                     logging.DefaultOptions(),
+		BulkWorkers:             handler.BulkWorkers,
+		BulkBatchSize:           handler.BulkBatchSize,
+		MaxBulkItems:            handler.MaxBulkItems,
+		CreateRateLimit:         handler.CreateRateLimit,
+		RedirectRateLimit:       handler.RedirectRateLimit,
+		UnlockRateLimit:         handler.UnlockRateLimit,
+		BulkRateLimit:           handler.BulkRateLimit,
+		LinkAccessTTL:           handler.LinkAccessTTL,
+	}
+}
+
+// Apply sets the package level settings of the handler and store packages,
+// loading the blocklist on the way.
+func (cfg *Config) Apply() error {
+	if cfg.BlocklistPath != "" {
+		blocklist, err := shortener.LoadBlocklist(cfg.BlocklistPath)
+		if err != nil {
+			return fmt.Errorf("load blocklist: %w", err)
+		}
+		handler.TargetPolicy.Blocklist = blocklist
+	}
+	generator, err := cfg.codeGenerator()
+	if err != nil {
+		return err
+	}
+	handler.CodeGenerator = generator
+	handler.TargetPolicy.MaxLength = cfg.MaxUrlLength
+	handler.BaseUrl = cfg.BaseUrl
+	handler.BulkWorkers = cfg.BulkWorkers
+	handler.BulkBatchSize = cfg.BulkBatchSize
+	handler.MaxBulkItems = cfg.MaxBulkItems
+	handler.CreateRateLimit = cfg.CreateRateLimit
+	handler.RedirectRateLimit = cfg.RedirectRateLimit
+	handler.PermanentRedirectMaxAge = cfg.PermanentRedirectMaxAge
+	handler.UnlockRateLimit = cfg.UnlockRateLimit
+	handler.BulkRateLimit = cfg.BulkRateLimit
+	if cfg.LinkAccessSecret != "" {
+		handler.LinkAccessSecret = []byte(cfg.LinkAccessSecret)
+	}
+	handler.LinkAccessTTL = cfg.LinkAccessTTL
+	store.DefaultTTL = cfg.DefaultTTL
+	store.ExpiredRetention = cfg.ExpiredRetention
+	store.IdempotencyKeyTTL = cfg.IdempotencyTTL
+	return nil
+}
+
+// register binds every setting of cfg to a flag of fs.
+func (cfg *Config) register(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.ListenAddr, "listen-addr", cfg.ListenAddr, "address the HTTP server listens on")
+	fs.Var((*listFlag)(&cfg.TrustedProxies), "trusted-proxies", "comma separated addresses and CIDR ranges of the proxies trusted to set X-Forwarded-For")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "how long requests in flight may take to finish on shutdown")
+	fs.StringVar(&cfg.BaseUrl, "base-url", cfg.BaseUrl, "public URL the short codes are appended to")
+	fs.IntVar(&cfg.MaxUrlLength, "max-url-length", cfg.MaxUrlLength, "longest long url accepted")
+	fs.StringVar(&cfg.BlocklistPath, "blocklist", cfg.BlocklistPath, "file of blocked target domains and url patterns")
+	fs.StringVar(&cfg.CodeStrategy, "code-strategy", cfg.CodeStrategy, "short code generator: hash, counter or random")
+	fs.StringVar(&cfg.CodeAlphabet, "code-alphabet", cfg.CodeAlphabet, "characters of generated codes, base58 for hash and base62 otherwise when empty")
+	fs.IntVar(&cfg.CodeLength, "code-length", cfg.CodeLength, "length of generated codes, the minimum one for counter codes")
+	fs.DurationVar(&cfg.DefaultTTL, "default-ttl", cfg.DefaultTTL, "lifetime of links created without an explicit expiry, 0 for never")
+	fs.DurationVar(&cfg.ExpiredRetention, "expired-retention", cfg.ExpiredRetention, "how long expired links keep answering 410 Gone")
+
+	fs.DurationVar(&cfg.IdempotencyTTL, "idempotency-ttl", cfg.IdempotencyTTL, "how long an Idempotency-Key replays the link it created")
+	fs.DurationVar(&cfg.PermanentRedirectMaxAge, "permanent-redirect-max-age", cfg.PermanentRedirectMaxAge, "how long clients may cache 301 and 308 redirects")
+
+	fs.StringVar(&cfg.Store.Backend, "store", cfg.Store.Backend, "storage backend: redis, memory or bolt")
+	fs.StringVar(&cfg.Store.RedisAddr, "redis-addr", cfg.Store.RedisAddr, "address of the redis server, or comma separated sentinel or cluster addresses")
+	fs.StringVar(&cfg.Store.RedisUsername, "redis-username", cfg.Store.RedisUsername, "ACL user of the redis server")
+	fs.StringVar(&cfg.Store.RedisPassword, "redis-password", cfg.Store.RedisPassword, "password of the redis server")
+	fs.IntVar(&cfg.Store.RedisDB, "redis-db", cfg.Store.RedisDB, "redis database number")
+	fs.StringVar(&cfg.Store.RedisMasterName, "redis-master-name", cfg.Store.RedisMasterName, "master monitored by the sentinels at redis-addr")
+	fs.StringVar(&cfg.Store.RedisSentinelPassword, "redis-sentinel-password", cfg.Store.RedisSentinelPassword, "password of the sentinels")
+	fs.BoolVar(&cfg.Store.RedisCluster, "redis-cluster", cfg.Store.RedisCluster, "treat redis-addr as a redis cluster even when it lists a single seed")
+	fs.StringVar(&cfg.Store.BoltPath, "bolt-path", cfg.Store.BoltPath, "database file used by the bolt backend")
+	fs.IntVar(&cfg.Store.CacheSize, "cache-size", cfg.Store.CacheSize, "links cached in process in front of the store, 0 to disable the cache")
+	fs.DurationVar(&cfg.Store.CacheTTL, "cache-ttl", cfg.Store.CacheTTL, "how long a cached link may lag behind changes made by other instances")
+	fs.DurationVar(&cfg.Store.CacheNegativeTTL, "cache-negative-ttl", cfg.Store.CacheNegativeTTL, "how long unknown short urls are cached, 0 to not cache them")
+	fs.DurationVar(&cfg.Store.ReadTimeout, "store-read-timeout", cfg.Store.ReadTimeout, "deadline of every read of the store, 0 for none")
+	fs.DurationVar(&cfg.Store.WriteTimeout, "store-write-timeout", cfg.Store.WriteTimeout, "deadline of every write to the store, 0 for none")
+	fs.IntVar(&cfg.Store.BreakerThreshold, "breaker-threshold", cfg.Store.BreakerThreshold, "consecutive store failures failing operations fast, 0 to never do so")
+	fs.DurationVar(&cfg.Store.BreakerCooldown, "breaker-cooldown", cfg.Store.BreakerCooldown, "how long operations fail fast before the store is tried again")
+
+	fs.StringVar(&cfg.Analytics.GeoIPPath, "geoip-db", cfg.Analytics.GeoIPPath, "MaxMind country database used to locate clicks")
+	fs.StringVar(&cfg.Analytics.IPHashSalt, "ip-hash-salt", cfg.Analytics.IPHashSalt, "salt for visitor IP hashes, random per process when empty")
+	fs.IntVar(&cfg.Analytics.BufferSize, "analytics-buffer", cfg.Analytics.BufferSize, "clicks queued before new ones are dropped")
+	fs.IntVar(&cfg.Analytics.Workers, "analytics-workers", cfg.Analytics.Workers, "goroutines writing clicks to the store")
+
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "lowest level logged: debug, info, warn or error")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "format of the log lines: json or text")
+
+	fs.IntVar(&cfg.BulkWorkers, "bulk-workers", cfg.BulkWorkers, "batches of a bulk request written to the store concurrently")
+	fs.IntVar(&cfg.BulkBatchSize, "bulk-batch-size", cfg.BulkBatchSize, "links written to the store in one batch")
+	fs.IntVar(&cfg.MaxBulkItems, "max-bulk-items", cfg.MaxBulkItems, "links accepted by one bulk request")
+
+	fs.Float64Var(&cfg.CreateRateLimit.Rate, "create-rate", cfg.CreateRateLimit.Rate, "links each API key may create per second, 0 for unlimited")
+	fs.IntVar(&cfg.CreateRateLimit.Burst, "create-burst", cfg.CreateRateLimit.Burst, "links an API key may create in a burst")
+	fs.Float64Var(&cfg.RedirectRateLimit.Rate, "redirect-rate", cfg.RedirectRateLimit.Rate, "redirects each client IP may follow per second, 0 for unlimited")
+	fs.IntVar(&cfg.RedirectRateLimit.Burst, "redirect-burst", cfg.RedirectRateLimit.Burst, "redirects a client IP may follow in a burst")
+	fs.Float64Var(&cfg.UnlockRateLimit.Rate, "unlock-rate", cfg.UnlockRateLimit.Rate, "passwords each client IP may try per second, 0 for unlimited")
+	fs.IntVar(&cfg.UnlockRateLimit.Burst, "unlock-burst", cfg.UnlockRateLimit.Burst, "passwords a client IP may try in a burst")
+	fs.Float64Var(&cfg.BulkRateLimit.Rate, "bulk-rate", cfg.BulkRateLimit.Rate, "links each API key may create per second through bulk requests, 0 for unlimited")
+	fs.IntVar(&cfg.BulkRateLimit.Burst, "bulk-burst", cfg.BulkRateLimit.Burst, "links an API key may create in a burst through bulk requests, and so in one of them")
+
+	fs.StringVar(&cfg.LinkAccessSecret, "link-access-secret", cfg.LinkAccessSecret, "key signing the cookies of unlocked links, random per process when empty")
+	fs.DurationVar(&cfg.LinkAccessTTL, "link-access-ttl", cfg.LinkAccessTTL, "how long an unlocked password protected link stays unlocked")
+}
+
+// listFlag is a comma separated list flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load registers the settings on fs, parses args with it and returns the
+// validated configuration. The file is named by the -config flag or the
+// SHORTENER_CONFIG variable; positional arguments are left in fs.Args().
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	return load(fs, args, os.LookupEnv)
+}
+
+func load(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+	cfg.register(fs)
+	path, _ := lookupEnv(envName("config"))
+	fs.StringVar(&path, "config", path, "YAML configuration file, overridden by "+EnvPrefix+"* variables and flags")
+
+	// The first pass only finds the file; flags are parsed again at the end
+	// so they win over the file and the environment.
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		if value, ok := lookupEnv(envName(f.Name)); ok {
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", envName(f.Name), err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	defer file.Close()
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// SequenceName is the store counter behind the counter code strategy.
+const SequenceName = "short_codes"
+
+func (cfg *Config) codeGenerator() (shortener.CodeGenerator, error) {
+	return shortener.NewCodeGenerator(cfg.CodeStrategy, cfg.CodeAlphabet, cfg.CodeLength, func(ctx context.Context) (uint64, error) {
+		return store.NextSequenceValue(ctx, SequenceName)
+	})
+}
+
+// Validate reports every invalid setting at once. It adds the trailing slash
+// BaseUrl may be missing.
+func (cfg *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.ListenAddr); err != nil {
+		invalid("listen_addr %q: %v", cfg.ListenAddr, err)
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		invalid("shutdown_timeout must be positive")
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			invalid("trusted_proxies: %q is neither an IP address nor a CIDR range", proxy)
+		}
+	}
+	base, err := url.Parse(cfg.BaseUrl)
+	switch {
+	case err != nil:
+		invalid("base_url %q: %v", cfg.BaseUrl, err)
+	case base.Scheme != "http" && base.Scheme != "https":
+		invalid("base_url %q must be an http or https url", cfg.BaseUrl)
+	case base.Host == "":
+		invalid("base_url %q has no host", cfg.BaseUrl)
+	case base.RawQuery != "" || base.Fragment != "":
+		invalid("base_url %q must not have a query or fragment", cfg.BaseUrl)
+	case !strings.HasSuffix(cfg.BaseUrl, "/"):
+		cfg.BaseUrl += "/"
+	}
+	if cfg.MaxUrlLength < 1 {
+		invalid("max_url_length must be positive")
+	}
+	if _, err := cfg.codeGenerator(); err != nil {
+		invalid("code generator: %v", err)
+	}
+	if cfg.DefaultTTL < 0 {
+		invalid("default_ttl must not be negative")
+	}
+	if cfg.ExpiredRetention < 0 {
+		invalid("expired_retention must not be negative")
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		invalid("idempotency_ttl must be positive")
+	}
+	if cfg.PermanentRedirectMaxAge < 0 {
+		invalid("permanent_redirect_max_age must not be negative")
+	}
+	if cfg.LinkAccessTTL <= 0 {
+		invalid("link_access_ttl must be positive")
+	}
+
+	switch cfg.Store.Backend {
+	case store.BackendRedis:
+		addrs := cfg.Store.RedisAddrs()
+		if len(addrs) == 0 {
+			invalid("store.redis_addr is required by the redis backend")
+		}
+		if cfg.Store.RedisDB < 0 {
+			invalid("store.redis_db must not be negative")
+		}
+		if cfg.Store.RedisCluster && cfg.Store.RedisMasterName != "" {
+			invalid("store.redis_cluster and store.redis_master_name are mutually exclusive")
+		}
+		cluster := cfg.Store.RedisCluster || (len(addrs) > 1 && cfg.Store.RedisMasterName == "")
+		if cluster && cfg.Store.RedisDB != 0 {
+			invalid("store.redis_db must be 0 in a redis cluster")
+		}
+	case store.BackendBolt:
+		if cfg.Store.BoltPath == "" {
+			invalid("store.bolt_path is required by the bolt backend")
+		}
+	case store.BackendMemory:
+	default:
+		invalid("unknown store backend %q", cfg.Store.Backend)
+	}
+	if cfg.Store.CacheSize < 0 {
+		invalid("store.cache_size must not be negative")
+	}
+	if cfg.Store.CacheSize > 0 && cfg.Store.CacheTTL <= 0 {
+		invalid("store.cache_ttl must be positive when the cache is enabled")
+	}
+	if cfg.Store.CacheNegativeTTL < 0 {
+		invalid("store.cache_negative_ttl must not be negative")
+	}
+	if cfg.Store.ReadTimeout < 0 || cfg.Store.WriteTimeout < 0 {
+		invalid("store.read_timeout and store.write_timeout must not be negative")
+	}
+	if cfg.Store.BreakerThreshold < 0 {
+		invalid("store.breaker_threshold must not be negative")
+	}
+	if cfg.Store.BreakerThreshold > 0 && cfg.Store.BreakerCooldown <= 0 {
+		invalid("store.breaker_cooldown must be positive when the breaker is enabled")
+	}
+
+	if cfg.Analytics.BufferSize < 1 {
+		invalid("analytics.buffer_size must be positive")
+	}
+	if cfg.Analytics.Workers < 1 {
+		invalid("analytics.workers must be positive")
+	}
+
+	if _, err := logging.ParseLevel(cfg.Log.Level); err != nil {
+		invalid("log.level: %v", err)
+	}
+	if cfg.Log.Format != logging.FormatJSON && cfg.Log.Format != logging.FormatText {
+		invalid("log.format must be %s or %s", logging.FormatJSON, logging.FormatText)
+	}
+
+	if cfg.BulkWorkers < 1 || cfg.BulkBatchSize < 1 || cfg.MaxBulkItems < 1 {
+		invalid("bulk_workers, bulk_batch_size and max_bulk_items must be positive")
+	}
+
+	for _, limit := range []struct {
+		name string
+		store.RateLimit
+	}{
+		{"create_rate_limit", cfg.CreateRateLimit},
+		{"redirect_rate_limit", cfg.RedirectRateLimit},
+		{"unlock_rate_limit", cfg.UnlockRateLimit},
+		{"bulk_rate_limit", cfg.BulkRateLimit},
+	} {
+		if limit.Rate < 0 {
+			invalid("%s.rate must not be negative", limit.name)
+		}
+		if !limit.Disabled() && limit.Burst < 1 {
+			invalid("%s.burst must be at least 1", limit.name)
+		}
+	}
+	return errors.Join(errs...)
+}
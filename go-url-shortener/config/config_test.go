@@ -0,0 +1,175 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/handler"
+	"go-url-shortener/shortener"
+	"go-url-shortener/store"
+)
+
+func loadWith(t *testing.T, args []string, env map[string]string) (*Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	return load(fs, args, func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "shortener.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestDefaults(t *testing.T) {
+	cfg, err := loadWith(t, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, ":9808", cfg.ListenAddr)
+	assert.Equal(t, "http://localhost:9808/", cfg.BaseUrl)
+	assert.Equal(t, store.BackendRedis, cfg.Store.Backend)
+	assert.Equal(t, "127.0.0.1:6379", cfg.Store.RedisAddr)
+	assert.Equal(t, "", cfg.Store.RedisPassword)
+	assert.Equal(t, 0, cfg.Store.RedisDB)
+	assert.Equal(t, 6*time.Hour, cfg.DefaultTTL)
+	assert.Empty(t, cfg.TrustedProxies)
+}
+
+func TestTrustedProxies(t *testing.T) {
+	cfg, err := loadWith(t, []string{"-trusted-proxies", "10.0.0.1, 192.168.0.0/16"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "192.168.0.0/16"}, cfg.TrustedProxies)
+
+	cfg, err = loadWith(t, nil, map[string]string{"SHORTENER_CONFIG": writeFile(t, "trusted_proxies: [172.16.0.0/12]\n")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"172.16.0.0/12"}, cfg.TrustedProxies)
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeFile(t, `
+listen_addr: ":8080"
+base_url: https://sho.rt
+default_ttl: 24h
+store:
+  redis_addr: redis.internal:6379
+  redis_db: 2
+`)
+	env := map[string]string{
+		"SHORTENER_CONFIG":      path,
+		"SHORTENER_REDIS_DB":    "3",
+		"SHORTENER_LISTEN_ADDR": ":8081",
+	}
+	cfg, err := loadWith(t, []string{"-listen-addr", ":8082", "extra"}, env)
+	require.NoError(t, err)
+
+	assert.Equal(t, ":8082", cfg.ListenAddr, "flags win over env and file")
+	assert.Equal(t, 3, cfg.Store.RedisDB, "env wins over the file")
+	assert.Equal(t, "redis.internal:6379", cfg.Store.RedisAddr)
+	assert.Equal(t, "https://sho.rt/", cfg.BaseUrl)
+	assert.Equal(t, 24*time.Hour, cfg.DefaultTTL)
+	assert.Equal(t, store.BackendRedis, cfg.Store.Backend, "defaults fill the rest")
+}
+
+func TestRedisSentinelConfig(t *testing.T) {
+	path := writeFile(t, `
+store:
+  redis_addr: "sentinel-1:26379, sentinel-2:26379"
+  redis_master_name: mymaster
+  redis_db: 2
+`)
+	cfg, err := loadWith(t, []string{"-config", path}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sentinel-1:26379", "sentinel-2:26379"}, cfg.Store.RedisAddrs())
+	assert.Equal(t, "mymaster", cfg.Store.RedisMasterName)
+}
+
+func TestConfigFlag(t *testing.T) {
+	path := writeFile(t, "base_url: https://example.com/s/\n")
+	cfg, err := loadWith(t, []string{"-config", path}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/s/", cfg.BaseUrl)
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args []string
+		env  map[string]string
+		file string
+	}{
+		"base url scheme":  {args: []string{"-base-url", "ftp://example.com"}},
+		"base url host":    {args: []string{"-base-url", "https://"}},
+		"listen addr":      {args: []string{"-listen-addr", "9808"}},
+		"trusted proxy":    {args: []string{"-trusted-proxies", "10.0.0.1,proxy.internal"}},
+		"unknown backend":  {args: []string{"-store", "mongo"}},
+		"redis addr":       {args: []string{"-redis-addr", ""}},
+		"cluster db":       {args: []string{"-redis-addr", "a:6379,b:6379", "-redis-db", "1"}},
+		"cluster sentinel": {args: []string{"-redis-cluster", "-redis-master-name", "mymaster"}},
+		"negative ttl":     {args: []string{"-default-ttl", "-1h"}},
+		"burst":            {args: []string{"-create-burst", "0"}},
+		"cache ttl":        {args: []string{"-cache-ttl", "0"}},
+		"store timeout":    {args: []string{"-store-read-timeout", "-1s"}},
+		"breaker cooldown": {args: []string{"-breaker-cooldown", "0"}},
+		"log level":        {args: []string{"-log-level", "loud"}},
+		"log format":       {env: map[string]string{"SHORTENER_LOG_FORMAT": "xml"}},
+		"env value":        {env: map[string]string{"SHORTENER_REDIS_DB": "two"}},
+		"unknown file key": {file: "listen_address: :80\n"},
+		"missing file":     {args: []string{"-config", "/does/not/exist.yaml"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			args := tc.args
+			if tc.file != "" {
+				args = append(args, "-config", writeFile(t, tc.file))
+			}
+			_, err := loadWith(t, args, tc.env)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestValidateReportsEverything(t *testing.T) {
+	cfg := Default()
+	cfg.ListenAddr = ""
+	cfg.Analytics.Workers = 0
+	cfg.Store.Backend = store.BackendBolt
+	cfg.Store.BoltPath = ""
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "listen_addr")
+	assert.Contains(t, err.Error(), "analytics.workers")
+	assert.Contains(t, err.Error(), "bolt_path")
+}
+
+func TestApplyLoadsBlocklist(t *testing.T) {
+	cfg := Default()
+	cfg.BlocklistPath = writeFile(t, "evil.example\n")
+	require.NoError(t, cfg.Apply())
+	t.Cleanup(func() { handler.TargetPolicy.Blocklist = nil })
+
+	_, err := handler.TargetPolicy.NormalizeTarget("https://www.evil.example/")
+	assert.ErrorIs(t, err, shortener.ErrTargetBlocked)
+
+	cfg.BlocklistPath = "/does/not/exist.txt"
+	assert.Error(t, cfg.Apply())
+}
+
+func TestCodeStrategy(t *testing.T) {
+	cfg, err := loadWith(t, []string{"-code-strategy", "counter", "-code-length", "5"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Apply())
+	t.Cleanup(func() { handler.CodeGenerator = shortener.DefaultHashGenerator() })
+	assert.IsType(t, shortener.CounterGenerator{}, handler.CodeGenerator)
+
+	_, err = loadWith(t, []string{"-code-strategy", "sequential"}, nil)
+	assert.Error(t, err)
+	_, err = loadWith(t, []string{"-code-alphabet", "a/b"}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go-url-shortener/auth"
+)
+
+// ApiKeyHeader is an alternative to "Authorization: Bearer <key>".
+const ApiKeyHeader = "X-API-Key"
+
+// userIdKey and apiKeyIdKey hold the authenticated user id and the id of the
+// key used in the gin context.
+const (
+	userIdKey   = "user_id"
+	apiKeyIdKey = "api_key_id"
+)
+
+func apiKeyFrom(c *gin.Context) string {
+	if key := c.GetHeader(ApiKeyHeader); key != "" {
+		return key
+	}
+	scheme, key, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
+
+// RequireApiKey rejects requests without a valid API key and makes the key's
+// owner the caller of the handlers behind it.
+func RequireApiKey(c *gin.Context) {
+	plaintext := apiKeyFrom(c)
+	if plaintext == "" {
+		abortWithError(c, http.StatusUnauthorized, CodeUnauthorized, "missing API key")
+		return
+	}
+	key, err := auth.Verify(c.Request.Context(), plaintext)
+	if errors.Is(err, auth.ErrInvalidKey) {
+		abortWithError(c, http.StatusUnauthorized, CodeUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.Set(userIdKey, key.UserId)
+	c.Set(apiKeyIdKey, key.Id)
+	c.Next()
+}
@@ -0,0 +1,482 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-url-shortener/auth"
+	"go-url-shortener/shortener"
+	"go-url-shortener/store"
+)
+
+// Bulk creation runs BulkWorkers batches of up to BulkBatchSize rows at a
+// time, each batch being written to the store in one go.
+var (
+	BulkWorkers   = 4
+	BulkBatchSize = 100
+	MaxBulkItems  = 10000
+)
+
+const maxBulkBodyBytes = 16 << 20
+
+const (
+	BulkCreated = "created"
+	BulkReused  = "reused"
+	BulkFailed  = "failed"
+)
+
+// bulkColumns are the CSV columns understood, long_url being required. Tags
+// are separated by semicolons.
+var bulkColumns = map[string]bool{
+	"long_url": true, "custom_alias": true, "title": true, "interstitial": true, "redirect_status": true,
+	"password": true, "tags": true, "ttl": true, "expires_at": true, "never_expires": true,
+}
+
+// BulkResult reports the outcome of one row, Row counting from 1 in the
+// order of the input.
+type BulkResult struct {
+	Row         int            `json:"row"`
+	LongUrl     string         `json:"long_url"`
+	CustomAlias string         `json:"custom_alias,omitempty"`
+	Status      string         `json:"status"`
+	Code        string         `json:"code,omitempty"`
+	ShortUrl    string         `json:"short_url,omitempty"`
+	ExpiresAt   *time.Time     `json:"expires_at,omitempty"`
+	Error       *ErrorResponse `json:"error,omitempty"`
+}
+
+type BulkResponse struct {
+	Created int          `json:"created"`
+	Reused  int          `json:"reused"`
+	Failed  int          `json:"failed"`
+	Results []BulkResult `json:"results"`
+}
+
+type bulkItem struct {
+	request UrlCreationRequest
+	record  *store.LinkRecord
+	attempt int
+	// duplicateOf is an earlier row of the request creating an equivalent link.
+	duplicateOf *bulkItem
+	result      BulkResult
+}
+
+func (item *bulkItem) fail(code string, message string) {
+	item.result.Status = BulkFailed
+	item.result.Error = &ErrorResponse{Code: code, Message: message}
+}
+
+func (item *bulkItem) failWithStoreError(ctx context.Context, err error) {
+	_, response := storeError(ctx, err)
+	item.fail(response.Code, response.Message)
+}
+
+func (item *bulkItem) succeed(status string) {
+	item.result.Status = status
+	item.result.Code = item.record.ShortUrl
+	item.result.ShortUrl = BaseUrl + item.record.ShortUrl
+	if !item.record.ExpiresAt.IsZero() {
+		expiresAt := item.record.ExpiresAt.UTC()
+		item.result.ExpiresAt = &expiresAt
+	}
+}
+
+func (item *bulkItem) done() bool {
+	return item.result.Status != ""
+}
+
+// CreateLinksBulk serves POST /api/links/bulk. It takes a JSON array of
+// creation requests, or a CSV file with a header row naming some of
+// bulkColumns, either as the body or as the "file" field of a multipart form.
+// Rows fail independently of each other and the response reports each one.
+// Every row takes a token of BulkRateLimit, the request being refused with
+// 429 when they are not all left.
+func CreateLinksBulk(c *gin.Context) {
+	userId, ok := callerId(c)
+	if !ok {
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBulkBodyBytes)
+	items, ok := parseBulk(c)
+	if !ok {
+		return
+	}
+	if len(items) == 0 {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, "no links to create")
+		return
+	}
+	maxItems := MaxBulkItems
+	if !BulkRateLimit.Disabled() && BulkRateLimit.Burst < maxItems {
+		maxItems = BulkRateLimit.Burst
+	}
+	if len(items) > maxItems {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, "at most "+strconv.Itoa(maxItems)+" links per request")
+		return
+	}
+	if !takeTokens(c, "bulk", BulkRateLimit, len(items)) {
+		return
+	}
+
+	pending := prepareBulk(items, userId, time.Now())
+	jobs := make(chan []*bulkItem)
+	var wg sync.WaitGroup
+	for i := 0; i < BulkWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				createBatch(c.Request.Context(), batch)
+			}
+		}()
+	}
+	for start := 0; start < len(pending); start += BulkBatchSize {
+		end := start + BulkBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		jobs <- pending[start:end]
+	}
+	close(jobs)
+	wg.Wait()
+
+	response := BulkResponse{Results: make([]BulkResult, 0, len(items))}
+	for _, item := range items {
+		if original := item.duplicateOf; original != nil {
+			item.record = original.record
+			if original.result.Status == BulkFailed {
+				item.result.Status, item.result.Error = BulkFailed, original.result.Error
+			} else {
+				item.succeed(BulkReused)
+			}
+		}
+		switch item.result.Status {
+		case BulkCreated:
+			response.Created++
+		case BulkReused:
+			response.Reused++
+		default:
+			response.Failed++
+		}
+		response.Results = append(response.Results, item.result)
+	}
+	linksCreated.WithLabelValues("bulk", "created").Add(float64(response.Created))
+	linksCreated.WithLabelValues("bulk", "reused").Add(float64(response.Reused))
+	c.JSON(http.StatusOK, response)
+}
+
+func parseBulk(c *gin.Context) ([]*bulkItem, bool) {
+	var (
+		items []*bulkItem
+		err   error
+	)
+	switch c.ContentType() {
+	case gin.MIMEJSON:
+		items, err = parseBulkJSON(c.Request.Body)
+	case "text/csv":
+		items, err = parseBulkCSV(c.Request.Body)
+	case gin.MIMEMultipartPOSTForm:
+		file, openErr := c.FormFile("file")
+		if openErr != nil {
+			abortWithError(c, http.StatusBadRequest, CodeBadRequest, "multipart upload needs a \"file\" field: "+openErr.Error())
+			return nil, false
+		}
+		upload, openErr := file.Open()
+		if openErr != nil {
+			abortWithError(c, http.StatusBadRequest, CodeBadRequest, openErr.Error())
+			return nil, false
+		}
+		defer upload.Close()
+		items, err = parseBulkCSV(upload)
+	default:
+		abortWithError(c, http.StatusUnsupportedMediaType, CodeUnsupportedMedia, "send application/json, text/csv or a multipart/form-data upload")
+		return nil, false
+	}
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return nil, false
+	}
+	return items, true
+}
+
+func parseBulkJSON(body io.Reader) ([]*bulkItem, error) {
+	var requests []UrlCreationRequest
+	if err := json.NewDecoder(body).Decode(&requests); err != nil {
+		return nil, fmt.Errorf("expected a JSON array of links: %w", err)
+	}
+	items := make([]*bulkItem, len(requests))
+	for i, request := range requests {
+		items[i] = &bulkItem{request: request, result: BulkResult{Row: i + 1, LongUrl: request.LongUrl, CustomAlias: request.CustomAlias}}
+	}
+	return items, nil
+}
+
+// parseBulkCSV fails on a malformed file; a malformed value only fails its
+// row.
+func parseBulkCSV(body io.Reader) ([]*bulkItem, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !bulkColumns[name] {
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["long_url"]; !ok {
+		return nil, errors.New("CSV header needs a long_url column")
+	}
+
+	var items []*bulkItem
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := func(column string) string {
+			if i, ok := columns[column]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		item := &bulkItem{request: UrlCreationRequest{LongUrl: value("long_url"), CustomAlias: value("custom_alias"), Title: value("title"), Password: value("password")}}
+		item.result = BulkResult{Row: len(items) + 1, LongUrl: item.request.LongUrl, CustomAlias: item.request.CustomAlias}
+		if interstitial := value("interstitial"); interstitial != "" {
+			var err error
+			if item.request.Interstitial, err = strconv.ParseBool(interstitial); err != nil {
+				item.fail(CodeBadRequest, "interstitial must be true or false")
+			}
+		}
+		if tags := value("tags"); tags != "" {
+			item.request.Tags = strings.Split(tags, ";")
+		}
+		if redirectStatus := value("redirect_status"); redirectStatus != "" && !item.done() {
+			var err error
+			if item.request.RedirectStatus, err = strconv.Atoi(redirectStatus); err != nil {
+				item.fail(CodeInvalidRedirect, errInvalidRedirectStatus.Error())
+			}
+		}
+		if err := parseCSVExpiry(&item.request.ExpiryPolicy, value("ttl"), value("expires_at"), value("never_expires")); err != nil && !item.done() {
+			item.fail(CodeInvalidExpiry, err.Error())
+		}
+		items = append(items, item)
+	}
+}
+
+func parseCSVExpiry(policy *ExpiryPolicy, ttl string, expiresAt string, neverExpires string) error {
+	if ttl != "" {
+		seconds, err := strconv.ParseInt(ttl, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: ttl must be a number of seconds", errInvalidExpiry)
+		}
+		policy.Ttl = &seconds
+	}
+	if expiresAt != "" {
+		deadline, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return fmt.Errorf("%w: expires_at must be an RFC 3339 time", errInvalidExpiry)
+		}
+		policy.ExpiresAt = &deadline
+	}
+	if neverExpires != "" {
+		never, err := strconv.ParseBool(neverExpires)
+		if err != nil {
+			return fmt.Errorf("%w: never_expires must be true or false", errInvalidExpiry)
+		}
+		policy.NeverExpires = never
+	}
+	return nil
+}
+
+// prepareBulk validates the rows and builds their records, returning the
+// ones left for the store. Rows repeating the url of an earlier row without
+// an alias are answered by that row. Passwords are only checked here, they
+// are hashed by the workers.
+func prepareBulk(items []*bulkItem, userId string, now time.Time) []*bulkItem {
+	pending := make([]*bulkItem, 0, len(items))
+	generated := map[string][]*bulkItem{}
+	for _, item := range items {
+		if item.done() {
+			continue
+		}
+		request := &item.request
+		request.UserId = userId
+		if request.LongUrl == "" {
+			item.fail(CodeBadRequest, "long_url is required")
+			continue
+		}
+		longUrl, err := checkTarget(request.LongUrl)
+		if err != nil {
+			item.fail(targetErrorCode(err), err.Error())
+			continue
+		}
+		request.LongUrl = longUrl
+		if err := checkTitle(request.Title); err != nil {
+			item.fail(CodeBadRequest, err.Error())
+			continue
+		}
+		if err := checkRedirectStatus(request.RedirectStatus); err != nil {
+			item.fail(CodeInvalidRedirect, err.Error())
+			continue
+		}
+		if request.Tags, err = normalizeTags(request.Tags); err != nil {
+			item.fail(CodeBadRequest, err.Error())
+			continue
+		}
+		if request.CustomAlias != "" {
+			if err := shortener.ValidateAlias(request.CustomAlias); err != nil {
+				item.fail(CodeInvalidAlias, err.Error())
+				continue
+			}
+		}
+		expiresAt, err := request.ExpiryPolicy.resolve(now, store.DefaultTTL)
+		if err != nil {
+			item.fail(CodeInvalidExpiry, err.Error())
+			continue
+		}
+
+		item.record = &store.LinkRecord{
+			ShortUrl:       request.CustomAlias,
+			OriginalUrl:    longUrl,
+			UserId:         userId,
+			CreatedAt:      now,
+			ExpiresAt:      expiresAt,
+			Title:          request.Title,
+			Interstitial:   request.Interstitial,
+			RedirectStatus: request.RedirectStatus,
+			Tags:           request.Tags,
+		}
+		if request.Password != "" {
+			if err := auth.ValidateLinkPassword(request.Password); err != nil {
+				item.record = nil
+				item.fail(CodeInvalidPassword, err.Error())
+				continue
+			}
+		}
+		// Protected links are never equivalent, their hashes being salted.
+		if request.CustomAlias == "" && request.Password == "" {
+			if original := findEquivalent(generated[longUrl], item.record); original != nil {
+				item.duplicateOf = original
+				continue
+			}
+			generated[longUrl] = append(generated[longUrl], item)
+		}
+		pending = append(pending, item)
+	}
+	return pending
+}
+
+// findEquivalent returns the item of candidates creating a link equivalent to
+// record.
+func findEquivalent(candidates []*bulkItem, record *store.LinkRecord) *bulkItem {
+	for _, candidate := range candidates {
+		if candidate.record.Equivalent(record) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// createBatch hashes the passwords of the batch and reuses the links the
+// user already has, then saves the rest in rounds, moving rows whose
+// generated code collided on to their next candidate.
+func createBatch(ctx context.Context, batch []*bulkItem) {
+	pending := make([]*bulkItem, 0, len(batch))
+	for _, item := range batch {
+		if item.request.Password != "" {
+			hash, err := auth.HashLinkPassword(item.request.Password)
+			if err != nil {
+				item.fail(CodeInvalidPassword, err.Error())
+				continue
+			}
+			item.record.PasswordHash = hash
+		}
+		if item.request.CustomAlias == "" {
+			existing, err := store.FindUserLink(ctx, item.record.UserId, item.record.OriginalUrl)
+			if err == nil && existing.Equivalent(item.record) {
+				item.record = existing
+				item.succeed(BulkReused)
+				continue
+			}
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				item.failWithStoreError(ctx, err)
+				continue
+			}
+		}
+		pending = append(pending, item)
+	}
+
+	for len(pending) > 0 {
+		records := make([]*store.LinkRecord, 0, len(pending))
+		candidates := make([]*bulkItem, 0, len(pending))
+		for _, item := range pending {
+			if item.request.CustomAlias == "" {
+				code, err := CodeGenerator.Generate(ctx, item.record.OriginalUrl, item.record.UserId, item.attempt)
+				if err != nil {
+					item.failWithStoreError(ctx, err)
+					continue
+				}
+				item.record.ShortUrl = code
+			}
+			records = append(records, item.record)
+			candidates = append(candidates, item)
+		}
+
+		pending = pending[:0]
+		for i, err := range store.CreateUrlMappings(ctx, records) {
+			item := candidates[i]
+			switch {
+			case err == nil:
+				item.succeed(BulkCreated)
+			case !errors.Is(err, store.ErrConflict):
+				item.failWithStoreError(ctx, err)
+			default:
+				if reused := reuseConflicting(ctx, item); reused {
+					continue
+				}
+				if item.request.CustomAlias != "" {
+					item.fail(CodeAliasTaken, "custom alias \""+item.request.CustomAlias+"\" is already taken")
+					continue
+				}
+				item.attempt++
+				if item.attempt >= shortener.MaxAttempts {
+					item.fail(CodeConflict, shortener.ErrNoFreeShortLink.Error())
+					continue
+				}
+				pending = append(pending, item)
+			}
+		}
+	}
+}
+
+// reuseConflicting answers item with the link holding its code when that is
+// already an equivalent link of the same user.
+func reuseConflicting(ctx context.Context, item *bulkItem) bool {
+	existing, err := store.RetrieveLink(ctx, item.record.ShortUrl)
+	if err != nil || !existing.Equivalent(item.record) {
+		return false
+	}
+	item.record = existing
+	item.succeed(BulkReused)
+	return true
+}
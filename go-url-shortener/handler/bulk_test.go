@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/auth"
+	"go-url-shortener/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newBulkRouter(t *testing.T) *gin.Engine {
+	r := newTestRouter(t)
+	r.POST("/api/links/bulk", RequireApiKey, CreateLinksBulk)
+	return r
+}
+
+func performBulk(t *testing.T, r http.Handler, contentType string, body []byte) (*httptest.ResponseRecorder, BulkResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/links/bulk", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(ApiKeyHeader, apiKeyFor(testUserId))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var resp BulkResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	}
+	return w, resp
+}
+
+func TestCreateLinksBulkJSON(t *testing.T) {
+	ctx := context.Background()
+	r := newBulkRouter(t)
+	require.NoError(t, store.CreateUrlMapping(ctx, &store.LinkRecord{ShortUrl: "taken-alias", OriginalUrl: "https://example.com/x", UserId: "someone-else"}))
+
+	body := `[
+		{"long_url":"https://example.com/a"},
+		{"long_url":"https://example.com/b","custom_alias":"bulk-b","ttl":60},
+		{"long_url":"javascript:alert(1)"},
+		{"long_url":"https://example.com/c","custom_alias":"taken-alias"},
+		{"long_url":"https://EXAMPLE.com/a"},
+		{"long_url":"https://example.com/d","ttl":-1},
+		{}
+	]`
+	w, resp := performBulk(t, r, "application/json", []byte(body))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Len(t, resp.Results, 7)
+	assert.Equal(t, 2, resp.Created)
+	assert.Equal(t, 1, resp.Reused)
+	assert.Equal(t, 4, resp.Failed)
+
+	statuses := make([]string, len(resp.Results))
+	for i, result := range resp.Results {
+		assert.Equal(t, i+1, result.Row)
+		statuses[i] = result.Status
+	}
+	assert.Equal(t, []string{BulkCreated, BulkCreated, BulkFailed, BulkFailed, BulkReused, BulkFailed, BulkFailed}, statuses)
+	assert.Equal(t, "bulk-b", resp.Results[1].Code)
+	assert.NotNil(t, resp.Results[1].ExpiresAt)
+	assert.Equal(t, CodeInvalidUrl, resp.Results[2].Error.Code)
+	assert.Equal(t, CodeAliasTaken, resp.Results[3].Error.Code)
+	assert.Equal(t, resp.Results[0].Code, resp.Results[4].Code)
+	assert.Equal(t, CodeInvalidExpiry, resp.Results[5].Error.Code)
+	assert.Equal(t, CodeBadRequest, resp.Results[6].Error.Code)
+
+	longUrl, err := store.RetrieveInitialUrl(ctx, resp.Results[0].Code)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/a", longUrl)
+
+	// Sending the batch again creates nothing new.
+	_, resp = performBulk(t, r, "application/json", []byte(body))
+	assert.Equal(t, 0, resp.Created)
+	assert.Equal(t, 3, resp.Reused)
+}
+
+func TestCreateLinksBulkCSV(t *testing.T) {
+	r := newBulkRouter(t)
+	csv := "long_url,custom_alias,ttl,never_expires\n" +
+		"https://example.com/1,,,\n" +
+		"https://example.com/2,csv-two,,true\n" +
+		"https://example.com/3,,soon,\n"
+
+	w, resp := performBulk(t, r, "text/csv", []byte(csv))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Len(t, resp.Results, 3)
+	assert.Equal(t, BulkCreated, resp.Results[0].Status)
+	assert.Equal(t, BulkCreated, resp.Results[1].Status)
+	assert.Nil(t, resp.Results[1].ExpiresAt)
+	assert.Equal(t, BulkFailed, resp.Results[2].Status)
+	assert.Equal(t, CodeInvalidExpiry, resp.Results[2].Error.Code)
+
+	// The same file as a multipart upload.
+	var upload bytes.Buffer
+	form := multipart.NewWriter(&upload)
+	part, err := form.CreateFormFile("file", "links.csv")
+	require.NoError(t, err)
+	_, err = part.Write([]byte(csv))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	w, resp = performBulk(t, r, form.FormDataContentType(), upload.Bytes())
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 2, resp.Reused)
+	assert.Equal(t, 1, resp.Failed)
+}
+
+func TestCreateLinksBulkPresentation(t *testing.T) {
+	r := newBulkRouter(t)
+	csv := "long_url,title,interstitial\n" +
+		"https://example.com/guarded,Guarded,true\n" +
+		"https://example.com/guarded,,\n" +
+		"https://example.com/guarded,Guarded,true\n" +
+		"https://example.com/bad,,maybe\n"
+
+	w, resp := performBulk(t, r, "text/csv", []byte(csv))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Len(t, resp.Results, 4)
+	assert.Equal(t, BulkCreated, resp.Results[0].Status)
+	assert.Equal(t, BulkCreated, resp.Results[1].Status, "a plain link is not the interstitial one")
+	assert.NotEqual(t, resp.Results[0].Code, resp.Results[1].Code)
+	assert.Equal(t, resp.Results[0].Code, resp.Results[2].Code)
+	assert.Equal(t, BulkFailed, resp.Results[3].Status)
+
+	record, err := store.RetrieveLink(context.Background(), resp.Results[0].Code)
+	require.NoError(t, err)
+	assert.Equal(t, "Guarded", record.Title)
+	assert.True(t, record.Interstitial)
+}
+
+func TestCreateLinksBulkManyBatches(t *testing.T) {
+	r := newBulkRouter(t)
+	BulkBatchSize = 7
+	t.Cleanup(func() { BulkBatchSize = 100 })
+
+	var rows []string
+	for i := 0; i < 100; i++ {
+		rows = append(rows, fmt.Sprintf(`{"long_url":"https://example.com/many/%d"}`, i))
+	}
+	w, resp := performBulk(t, r, "application/json", []byte("["+strings.Join(rows, ",")+"]"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 100, resp.Created)
+
+	shortUrls, err := store.ListUserUrls(context.Background(), testUserId)
+	require.NoError(t, err)
+	assert.Len(t, shortUrls, 100)
+}
+
+func TestCreateLinksBulkBadRequests(t *testing.T) {
+	r := newBulkRouter(t)
+
+	for _, tc := range []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/json", `{"long_url":"https://example.com"}`, http.StatusBadRequest},
+		{"application/json", `[]`, http.StatusBadRequest},
+		{"text/csv", "url\nhttps://example.com\n", http.StatusBadRequest},
+		{"text/csv", "custom_alias\nabc\n", http.StatusBadRequest},
+		{"text/csv", "long_url,custom_alias\n\"unterminated\n", http.StatusBadRequest},
+		{"text/plain", "https://example.com", http.StatusUnsupportedMediaType},
+	} {
+		w, _ := performBulk(t, r, tc.contentType, []byte(tc.body))
+		assert.Equal(t, tc.status, w.Code, tc.body)
+	}
+
+	MaxBulkItems = 2
+	t.Cleanup(func() { MaxBulkItems = 10000 })
+	w, _ := performBulk(t, r, "application/json", []byte(`[{"long_url":"https://example.com/1"},{"long_url":"https://example.com/2"},{"long_url":"https://example.com/3"}]`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCreateLinksBulkRateLimit(t *testing.T) {
+	r := newBulkRouter(t)
+	BulkRateLimit = store.RateLimit{Rate: 1, Burst: 5}
+	t.Cleanup(func() { BulkRateLimit = store.RateLimit{Rate: 2, Burst: 10000} })
+	rows := func(from int, n int) []byte {
+		urls := make([]string, n)
+		for i := range urls {
+			urls[i] = fmt.Sprintf(`{"long_url":"https://example.com/quota/%d"}`, from+i)
+		}
+		return []byte("[" + strings.Join(urls, ",") + "]")
+	}
+
+	w, _ := performBulk(t, r, "application/json", rows(0, 6))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "more rows than the burst")
+
+	w, resp := performBulk(t, r, "application/json", rows(0, 4))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 4, resp.Created)
+
+	w, _ = performBulk(t, r, "application/json", rows(4, 2))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "every row takes a token")
+	assert.Equal(t, CodeRateLimited, decodeError(t, w).Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	w, resp = performBulk(t, r, "application/json", rows(4, 1))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, resp.Created)
+}
+
+func TestCreateLinksBulkPasswords(t *testing.T) {
+	r := newBulkRouter(t)
+	auth.LinkPasswordCost = bcrypt.MinCost
+	body := `[
+		{"long_url":"https://example.com/locked","password":"hunter22"},
+		{"long_url":"https://example.com/locked","password":"hunter22"},
+		{"long_url":"https://example.com/locked","password":"abc"}
+	]`
+
+	// Hashing is left to the workers.
+	items, err := parseBulkJSON(strings.NewReader(body))
+	require.NoError(t, err)
+	pending := prepareBulk(items, testUserId, time.Now())
+	require.Len(t, pending, 2, "protected rows are not deduplicated")
+	for _, item := range pending {
+		assert.Empty(t, item.record.PasswordHash)
+	}
+	assert.Equal(t, CodeInvalidPassword, items[2].result.Error.Code)
+
+	w, resp := performBulk(t, r, "application/json", []byte(body))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, resp.Created)
+	assert.Equal(t, 1, resp.Failed)
+	assert.NotEqual(t, resp.Results[0].Code, resp.Results[1].Code)
+	record, err := store.RetrieveLink(context.Background(), resp.Results[0].Code)
+	require.NoError(t, err)
+	assert.True(t, auth.CheckLinkPassword(record, "hunter22"))
+}
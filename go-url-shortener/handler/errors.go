@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go-url-shortener/store"
+)
+
+// ErrorResponse is the body of every non 2xx JSON response.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"error"`
+}
+
+const (
+	CodeBadRequest         = "bad_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeExpired            = "expired"
+	CodeConflict           = "conflict"
+	CodeInvalidAlias       = "invalid_alias"
+	CodeAliasTaken         = "alias_taken"
+	CodeInvalidRedirect    = "invalid_redirect_status"
+	CodeInvalidPassword    = "invalid_password"
+	CodeDisabled           = "link_disabled"
+	CodeInvalidExpiry      = "invalid_expiry"
+	CodeInvalidUrl         = "invalid_url"
+	CodeSelfReference      = "self_reference"
+	CodeBlockedUrl         = "blocked_url"
+	CodeIdempotencyReused  = "idempotency_key_reused"
+	CodeUnsupportedMedia   = "unsupported_media_type"
+	CodeRateLimited        = "rate_limited"
+	CodeServiceUnavailable = "service_unavailable"
+	CodeInternal           = "internal_error"
+)
+
+func abortWithError(c *gin.Context, status int, code string, message string) {
+	c.AbortWithStatusJSON(status, ErrorResponse{Code: code, Message: message})
+}
+
+// storeError maps the store sentinel errors onto HTTP statuses and bodies.
+// Anything else is logged and answered with a fixed message, so the details
+// of the failure stay out of the response.
+func storeError(ctx context.Context, err error) (int, ErrorResponse) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound, ErrorResponse{Code: CodeNotFound, Message: "short url not found"}
+	case errors.Is(err, store.ErrExpired):
+		return http.StatusGone, ErrorResponse{Code: CodeExpired, Message: "short url has expired"}
+	case errors.Is(err, store.ErrConflict):
+		return http.StatusConflict, ErrorResponse{Code: CodeConflict, Message: "short url already taken"}
+	case errors.Is(err, store.ErrBackendUnavailable):
+		return http.StatusServiceUnavailable, ErrorResponse{Code: CodeServiceUnavailable, Message: "storage temporarily unavailable"}
+	default:
+		slog.ErrorContext(ctx, "request failed", "error", err)
+		return http.StatusInternalServerError, ErrorResponse{Code: CodeInternal, Message: "internal error"}
+	}
+}
+
+func abortWithStoreError(c *gin.Context, err error) {
+	status, response := storeError(c.Request.Context(), err)
+	c.AbortWithStatusJSON(status, response)
+}
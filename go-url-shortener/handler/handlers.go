@@ -1,37 +1,345 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go-url-shortener/analytics"
+	"go-url-shortener/logging"
 	"go-url-shortener/shortener"
 	"go-url-shortener/store"
 )
 
+// ExpiryPolicy accepts at most one of ExpiresAt, Ttl (in seconds) and
+// NeverExpires.
+type ExpiryPolicy struct {
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	Ttl          *int64     `json:"ttl,omitempty"`
+	NeverExpires bool       `json:"never_expires,omitempty"`
+}
+
+// UrlCreationRequest falls back to store.DefaultTTL when its ExpiryPolicy is
+// left empty. UserId is ignored: links belong to the owner of the API key.
 type UrlCreationRequest struct {
-	LongUrl string `json:"long_url" binding:"required"`
-	UserId  string `json:"user_id" binding:"required"`
+	LongUrl      string `json:"long_url" binding:"required"`
+	UserId       string `json:"user_id,omitempty"`
+	CustomAlias  string `json:"custom_alias,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Interstitial bool   `json:"interstitial,omitempty"`
+	// RedirectStatus is one of RedirectStatuses, store.DefaultRedirectStatus
+	// when left out.
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	// Password protects the link; only its bcrypt hash is stored.
+	Password string   `json:"password,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	ExpiryPolicy
+}
+
+var errInvalidExpiry = errors.New("invalid expiry")
+
+func (policy ExpiryPolicy) empty() bool {
+	return policy.ExpiresAt == nil && policy.Ttl == nil && !policy.NeverExpires
+}
+
+// resolve turns policy into a deadline, the zero time meaning the link never
+// expires. An empty policy resolves to fallback from now, or to never when
+// fallback is zero.
+func (policy ExpiryPolicy) resolve(now time.Time, fallback time.Duration) (time.Time, error) {
+	set := 0
+	for _, given := range []bool{policy.ExpiresAt != nil, policy.Ttl != nil, policy.NeverExpires} {
+		if given {
+			set++
+		}
+	}
+	if set > 1 {
+		return time.Time{}, fmt.Errorf("%w: expires_at, ttl and never_expires are mutually exclusive", errInvalidExpiry)
+	}
+
+	switch {
+	case policy.ExpiresAt != nil:
+		if !policy.ExpiresAt.After(now) {
+			return time.Time{}, fmt.Errorf("%w: expires_at must be in the future", errInvalidExpiry)
+		}
+		return *policy.ExpiresAt, nil
+	case policy.Ttl != nil:
+		if *policy.Ttl <= 0 {
+			return time.Time{}, fmt.Errorf("%w: ttl must be a positive number of seconds", errInvalidExpiry)
+		}
+		return now.Add(time.Duration(*policy.Ttl) * time.Second), nil
+	case policy.NeverExpires || fallback == 0:
+		return time.Time{}, nil
+	default:
+		return now.Add(fallback), nil
+	}
 }
 
+// BaseUrl prefixes every short code handed out to clients.
+var BaseUrl = "http://localhost:9808/"
+
+// TargetPolicy checks every long_url. The host of BaseUrl is always added to
+// its SelfHosts.
+var TargetPolicy = shortener.DefaultTargetPolicy()
+
+// checkTarget validates and normalizes a long_url against TargetPolicy.
+func checkTarget(raw string) (string, error) {
+	policy := TargetPolicy
+	if base, err := url.Parse(BaseUrl); err == nil && base.Host != "" {
+		policy.SelfHosts = append([]string{base.Host}, TargetPolicy.SelfHosts...)
+	}
+	return policy.NormalizeTarget(raw)
+}
+
+// targetErrorCode is the error code of a url refused by checkTarget.
+func targetErrorCode(err error) string {
+	switch {
+	case errors.Is(err, shortener.ErrTargetBlocked):
+		return CodeBlockedUrl
+	case errors.Is(err, shortener.ErrTargetSelfReference):
+		return CodeSelfReference
+	default:
+		return CodeInvalidUrl
+	}
+}
+
+// normalizeTarget is checkTarget answering the request with 422 when the url
+// is rejected.
+func normalizeTarget(c *gin.Context, raw string) (string, bool) {
+	target, err := checkTarget(raw)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, targetErrorCode(err), err.Error())
+		return "", false
+	}
+	return target, true
+}
+
+// CreateShortUrl answers with the link of the caller already mapping the same
+// normalized url when there is one, reporting it through "created".
 func CreateShortUrl(c *gin.Context) {
+	userId, ok := callerId(c)
+	if !ok {
+		return
+	}
 	var creationRequest UrlCreationRequest
 	if err := c.ShouldBindJSON(&creationRequest); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	creationRequest.UserId = userId
+	if creationRequest.LongUrl, ok = normalizeTarget(c, creationRequest.LongUrl); !ok {
+		return
+	}
+	if err := checkTitle(creationRequest.Title); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if err := checkRedirectStatus(creationRequest.RedirectStatus); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRedirect, err.Error())
+		return
+	}
+	var err error
+	if creationRequest.Tags, err = normalizeTags(creationRequest.Tags); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	idempotency, ok := idempotencyFor(c, creationRequest)
+	if !ok {
 		return
 	}
+	if idempotency != nil {
+		if replayed := replayIdempotent(c, idempotency); replayed {
+			return
+		}
+	}
 
-	shortUrl := shortener.GenerateShortLink(creationRequest.LongUrl, creationRequest.UserId)
-	store.SaveUrlMapping(shortUrl, creationRequest.LongUrl, creationRequest.UserId)
+	now := time.Now()
+	expiresAt, err := creationRequest.ExpiryPolicy.resolve(now, store.DefaultTTL)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidExpiry, err.Error())
+		return
+	}
+	record := &store.LinkRecord{
+		OriginalUrl:    creationRequest.LongUrl,
+		UserId:         creationRequest.UserId,
+		CreatedAt:      now,
+		ExpiresAt:      expiresAt,
+		Title:          creationRequest.Title,
+		Interstitial:   creationRequest.Interstitial,
+		RedirectStatus: creationRequest.RedirectStatus,
+		Tags:           creationRequest.Tags,
+	}
+	if !hashPassword(c, record, creationRequest.Password) {
+		return
+	}
 
-	host := "http://localhost:9808/"
+	claim := claimGeneratedLink
+	if creationRequest.CustomAlias != "" {
+		claim = claimAlias
+	}
+	created, err := claim(c, record, creationRequest)
+	if err != nil {
+		return
+	}
+	if idempotency != nil {
+		rememberIdempotent(c.Request.Context(), idempotency, record)
+	}
+	countCreation("api", created)
+	respondCreation(c, record, created)
+}
+
+func respondCreation(c *gin.Context, record *store.LinkRecord, created bool) {
+	message := "short url created successfully"
+	if !created {
+		message = "existing short url reused"
+	}
 	c.JSON(200, gin.H{
-		"message":   "short url created successfully",
-		"short_url": host + shortUrl,
+		"message":            message,
+		"created":            created,
+		"code":               record.ShortUrl,
+		"short_url":          BaseUrl + record.ShortUrl,
+		"long_url":           record.OriginalUrl,
+		"created_at":         record.CreatedAt.UTC().Format(time.RFC3339),
+		"expires_at":         expiryJSON(record.ExpiresAt),
+		"title":              record.Title,
+		"interstitial":       record.Interstitial,
+		"redirect_status":    record.RedirectCode(),
+		"password_protected": record.PasswordHash != "",
+		"tags":               tagsJSON(record.Tags),
+	})
+}
+
+// tagsJSON renders no tags as an empty list.
+func tagsJSON(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// expiryJSON renders a never expiring deadline as null.
+func expiryJSON(expiresAt time.Time) interface{} {
+	if expiresAt.IsZero() {
+		return nil
+	}
+	return expiresAt.UTC().Format(time.RFC3339)
+}
+
+// claimAlias reserves the requested vanity alias for record, answering the
+// request itself when the alias is malformed or already taken. An alias the
+// caller already holds for the same url is reused.
+func claimAlias(c *gin.Context, record *store.LinkRecord, creationRequest UrlCreationRequest) (bool, error) {
+	alias := creationRequest.CustomAlias
+	if err := shortener.ValidateAlias(alias); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidAlias, err.Error())
+		return false, err
+	}
+	record.ShortUrl = alias
+	created, err := store.SaveOrReuseUrlMapping(c.Request.Context(), record)
+	if errors.Is(err, store.ErrConflict) {
+		abortWithError(c, http.StatusConflict, CodeAliasTaken, "custom alias \""+alias+"\" is already taken")
+		return false, err
+	}
+	if err != nil {
+		abortWithStoreError(c, err)
+		return false, err
+	}
+	return created, nil
+}
+
+// CodeGenerator produces the codes of links created without a custom alias.
+var CodeGenerator shortener.CodeGenerator = shortener.DefaultHashGenerator()
+
+// claimGeneratedLink reuses the link the caller already has for the url, when
+// it is presented the same way, or claims a fresh code from CodeGenerator for
+// record, retrying on collisions. It answers the request itself when that
+// fails.
+func claimGeneratedLink(c *gin.Context, record *store.LinkRecord, creationRequest UrlCreationRequest) (bool, error) {
+	existing, err := store.FindUserLink(c.Request.Context(), record.UserId, record.OriginalUrl)
+	if err == nil && existing.Equivalent(record) {
+		*record = *existing
+		return false, nil
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		abortWithStoreError(c, err)
+		return false, err
+	}
+
+	created := false
+	_, err = shortener.GenerateUniqueCode(c.Request.Context(), CodeGenerator, creationRequest.LongUrl, creationRequest.UserId, func(candidate string) (bool, error) {
+		record.ShortUrl = candidate
+		var err error
+		created, err = store.SaveOrReuseUrlMapping(c.Request.Context(), record)
+		if errors.Is(err, store.ErrConflict) {
+			return false, nil
+		}
+		return err == nil, err
 	})
+	if errors.Is(err, shortener.ErrNoFreeShortLink) {
+		abortWithError(c, http.StatusConflict, CodeConflict, err.Error())
+		return false, err
+	}
+	if err != nil {
+		abortWithStoreError(c, err)
+		return false, err
+	}
+	return created, nil
 }
 
+// retrieveCode loads the link named by a path parameter. Anything but a code
+// is not found without asking the store, so path input never addresses the
+// other keys of a backend.
+func retrieveCode(ctx context.Context, code string) (*store.LinkRecord, error) {
+	if !shortener.IsCode(code) {
+		return nil, store.ErrNotFound
+	}
+	return store.RetrieveLink(ctx, code)
+}
+
+// HandleShortUrlRedirect sends visitors on to the original url with the
+// redirect status of the link, through the interstitial page for links asking
+// for one and the password form for protected ones. Disabled links answer
+// 410. Short urls ending in PreviewSuffix are previewed instead.
 func HandleShortUrlRedirect(c *gin.Context) {
 	shortUrl := c.Param("shortUrl")
-	initialUrl := store.RetrieveInitialUrl(shortUrl)
-	c.Redirect(302, initialUrl)
+	if strings.HasSuffix(shortUrl, PreviewSuffix) {
+		previewLink(c, strings.TrimSuffix(shortUrl, PreviewSuffix))
+		return
+	}
+	record, err := retrieveCode(c.Request.Context(), shortUrl)
+	if err != nil {
+		redirects.WithLabelValues(redirectFailure(err)).Inc()
+		abortWithStoreError(c, err)
+		return
+	}
+	if record.Disabled {
+		redirects.WithLabelValues(redirectDisabled).Inc()
+		abortWithError(c, http.StatusGone, CodeDisabled, "short url has been disabled")
+		return
+	}
+	if !requireLinkAccess(c, record, false) {
+		redirects.WithLabelValues(redirectLocked).Inc()
+		return
+	}
+	redirects.WithLabelValues(redirectHit).Inc()
+	// Showing the interstitial counts as the click: the continue button leads
+	// straight to the destination.
+	analytics.RecordClick(analytics.Click{
+		ShortUrl:  shortUrl,
+		Timestamp: time.Now(),
+		Referrer:  c.Request.Referer(),
+		UserAgent: c.Request.UserAgent(),
+		ClientIP:  c.ClientIP(),
+		RequestID: logging.RequestID(c.Request.Context()),
+	})
+	if record.Interstitial {
+		renderPage(c, "interstitial.html", record)
+		return
+	}
+	c.Header("Cache-Control", redirectCacheControl(record, time.Now()))
+	c.Redirect(record.RedirectCode(), record.OriginalUrl)
 }
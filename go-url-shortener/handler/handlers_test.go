@@ -0,0 +1,338 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/auth"
+	"go-url-shortener/shortener"
+	"go-url-shortener/store"
+)
+
+const testUserId = "e0dba740-fc4b-4977-872c-d360239e6b1a"
+
+// apiKeys caches the key minted for each test user in the current store.
+var apiKeys map[string]string
+
+func apiKeyFor(userId string) string {
+	if key, ok := apiKeys[userId]; ok {
+		return key
+	}
+	key, _, err := auth.Mint(context.Background(), userId)
+	if err != nil {
+		panic(err)
+	}
+	apiKeys[userId] = key
+	return key
+}
+
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	_, err := store.InitializeStore(store.Options{Backend: store.BackendMemory})
+	require.NoError(t, err)
+	apiKeys = map[string]string{}
+
+	r := gin.New()
+	r.POST("/create-short-url", RequireApiKey, CreateShortUrl)
+	r.GET("/:shortUrl", HandleShortUrlRedirect)
+	r.GET("/api/links/:code/stats", RequireApiKey, LinkStats)
+	return r
+}
+
+func perform(r http.Handler, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(ApiKeyHeader, apiKeyFor(testUserId))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) ErrorResponse {
+	t.Helper()
+	var resp ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestCreateAndRedirect(t *testing.T) {
+	r := newTestRouter(t)
+	longUrl := "https://www.eddywm.com/lets-build-a-url-shortener-in-go/"
+
+	w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: longUrl, UserId: testUserId})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	shortUrl := shortener.GenerateShortLink(longUrl, testUserId)
+	w = perform(r, http.MethodGet, "/"+shortUrl, nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, longUrl, w.Header().Get("Location"))
+}
+
+func TestCreateShortUrlBadRequest(t *testing.T) {
+	r := newTestRouter(t)
+
+	w := perform(r, http.MethodPost, "/create-short-url", gin.H{"user_id": testUserId})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CodeBadRequest, decodeError(t, w).Code)
+}
+
+func TestCreateShortUrlCollision(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRouter(t)
+	longUrl := "https://example.com/collision"
+
+	// Occupy the first candidate with somebody else's url.
+	taken := shortener.GenerateShortLink(longUrl, testUserId)
+	require.NoError(t, store.SaveUrlMapping(ctx, &store.LinkRecord{ShortUrl: taken, OriginalUrl: "https://example.com/other", UserId: "another-user"}))
+
+	w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: longUrl, UserId: testUserId})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := decodeBody(t, w)
+	retry, err := shortener.DefaultHashGenerator().Generate(ctx, longUrl, testUserId, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:9808/"+retry, resp["short_url"])
+
+	// The existing mapping was left untouched.
+	original, err := store.RetrieveInitialUrl(ctx, taken)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/other", original)
+}
+
+func TestCreateShortUrlResubmit(t *testing.T) {
+	r := newTestRouter(t)
+	longUrl := "https://example.com/twice"
+	body := UrlCreationRequest{LongUrl: longUrl, UserId: testUserId}
+
+	first := perform(r, http.MethodPost, "/create-short-url", body)
+	require.Equal(t, http.StatusOK, first.Code)
+	ttl := int64(60)
+	body.ExpiryPolicy = ExpiryPolicy{Ttl: &ttl}
+	second := perform(r, http.MethodPost, "/create-short-url", body)
+	require.Equal(t, http.StatusOK, second.Code)
+
+	firstBody, secondBody := decodeBody(t, first), decodeBody(t, second)
+	assert.Equal(t, true, firstBody["created"])
+	assert.Equal(t, false, secondBody["created"])
+	assert.Equal(t, firstBody["short_url"], secondBody["short_url"])
+	// The original metadata is kept, the new expiry is not applied.
+	assert.Equal(t, firstBody["created_at"], secondBody["created_at"])
+	assert.Equal(t, firstBody["expires_at"], secondBody["expires_at"])
+
+	// Another user shortening the same url gets a link of their own.
+	third := performAs(r, "someone-else", http.MethodPost, "/create-short-url", `{"long_url":"`+longUrl+`"}`)
+	require.Equal(t, http.StatusOK, third.Code)
+	assert.Equal(t, true, decodeBody(t, third)["created"])
+	assert.NotEqual(t, firstBody["short_url"], decodeBody(t, third)["short_url"])
+}
+
+func TestRedirectNotFound(t *testing.T) {
+	r := newTestRouter(t)
+
+	w := perform(r, http.MethodGet, "/unknown1", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeNotFound, decodeError(t, w).Code)
+}
+
+func TestCreateShortUrlWithCustomAlias(t *testing.T) {
+	r := newTestRouter(t)
+	longUrl := "https://example.com/spring"
+
+	w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: longUrl, UserId: testUserId, CustomAlias: "spring-sale"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "http://localhost:9808/spring-sale")
+
+	w = perform(r, http.MethodGet, "/spring-sale", nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, longUrl, w.Header().Get("Location"))
+
+	// Taken, even by another user's request for the very same url.
+	w = performAs(r, "someone-else", http.MethodPost, "/create-short-url", `{"long_url":"`+longUrl+`","custom_alias":"spring-sale"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, CodeAliasTaken, decodeError(t, w).Code)
+
+	// The owner asking again gets it back.
+	w = perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: longUrl, CustomAlias: "spring-sale"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, false, decodeBody(t, w)["created"])
+}
+
+func TestCreateShortUrlInvalidAlias(t *testing.T) {
+	r := newTestRouter(t)
+
+	for _, alias := range []string{"create-short-url", "no spaces", "x"} {
+		w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: "https://example.com", UserId: testUserId, CustomAlias: alias})
+		assert.Equal(t, http.StatusBadRequest, w.Code, alias)
+		assert.Equal(t, CodeInvalidAlias, decodeError(t, w).Code, alias)
+	}
+}
+
+func TestCreateShortUrlExpiry(t *testing.T) {
+	r := newTestRouter(t)
+	ttl := int64(3600)
+	past := time.Now().Add(-time.Hour)
+
+	w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: "https://example.com/ttl", UserId: testUserId, ExpiryPolicy: ExpiryPolicy{Ttl: &ttl}})
+	require.Equal(t, http.StatusOK, w.Code)
+	expiresAt, err := time.Parse(time.RFC3339, decodeBody(t, w)["expires_at"].(string))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+
+	w = perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: "https://example.com/forever", UserId: testUserId, ExpiryPolicy: ExpiryPolicy{NeverExpires: true}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, decodeBody(t, w)["expires_at"])
+
+	w = perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: "https://example.com/default", UserId: testUserId})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotNil(t, decodeBody(t, w)["expires_at"])
+
+	for _, bad := range []UrlCreationRequest{
+		{LongUrl: "https://example.com/past", UserId: testUserId, ExpiryPolicy: ExpiryPolicy{ExpiresAt: &past}},
+		{LongUrl: "https://example.com/both", UserId: testUserId, ExpiryPolicy: ExpiryPolicy{Ttl: &ttl, NeverExpires: true}},
+	} {
+		w = perform(r, http.MethodPost, "/create-short-url", bad)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, CodeInvalidExpiry, decodeError(t, w).Code)
+	}
+}
+
+func TestRedirectExpired(t *testing.T) {
+	r := newTestRouter(t)
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{
+		ShortUrl:    "expired1",
+		OriginalUrl: "https://example.com/old",
+		UserId:      testUserId,
+		ExpiresAt:   time.Now().Add(-time.Minute),
+	}))
+
+	w := perform(r, http.MethodGet, "/expired1", nil)
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Equal(t, CodeExpired, decodeError(t, w).Code)
+}
+
+func TestCreateShortUrlRequiresApiKey(t *testing.T) {
+	r := newTestRouter(t)
+	body := `{"long_url":"https://example.com/auth"}`
+
+	w := performAs(r, "", http.MethodPost, "/create-short-url", body)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, CodeUnauthorized, decodeError(t, w).Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/create-short-url", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer sk_0000_forged")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/create-short-url", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+apiKeyFor("frank"))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCreateShortUrlOwnedByApiKeyUser(t *testing.T) {
+	r := newTestRouter(t)
+	longUrl := "https://example.com/owned"
+
+	// The user_id of the body cannot override the authenticated identity.
+	w := performAs(r, "grace", http.MethodPost, "/create-short-url", `{"long_url":"`+longUrl+`","user_id":"heidi"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	record, err := store.RetrieveLink(context.Background(), shortener.GenerateShortLink(longUrl, "grace"))
+	require.NoError(t, err)
+	assert.Equal(t, "grace", record.UserId)
+}
+
+func TestCreateShortUrlRejectsTargets(t *testing.T) {
+	r := newTestRouter(t)
+
+	for longUrl, code := range map[string]string{
+		"javascript:alert(1)":         CodeInvalidUrl,
+		"/relative":                   CodeInvalidUrl,
+		"https://":                    CodeInvalidUrl,
+		"http://localhost:9808/abc12": CodeSelfReference,
+	} {
+		w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: longUrl})
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, longUrl)
+		assert.Equal(t, code, decodeError(t, w).Code, longUrl)
+	}
+}
+
+func TestCreateShortUrlNormalizesTarget(t *testing.T) {
+	r := newTestRouter(t)
+
+	w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: "HTTPS://Example.com:443", CustomAlias: "normalized"})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	longUrl, err := store.RetrieveInitialUrl(context.Background(), "normalized")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/", longUrl)
+}
+
+func TestCreateShortUrlWithRandomCodes(t *testing.T) {
+	r := newTestRouter(t)
+	CodeGenerator = shortener.RandomGenerator{Alphabet: shortener.Base62Alphabet, Length: 6}
+	t.Cleanup(func() { CodeGenerator = shortener.DefaultHashGenerator() })
+	body := UrlCreationRequest{LongUrl: "https://example.com/random"}
+
+	first := perform(r, http.MethodPost, "/create-short-url", body)
+	require.Equal(t, http.StatusOK, first.Code)
+	code := decodeBody(t, first)["code"].(string)
+	assert.Len(t, code, 6)
+
+	// Deduplication does not depend on codes being derived from the url.
+	second := perform(r, http.MethodPost, "/create-short-url", body)
+	require.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, false, decodeBody(t, second)["created"])
+	assert.Equal(t, code, decodeBody(t, second)["code"])
+}
+
+func TestLinkRoutesRejectNonCodes(t *testing.T) {
+	r := newProtectRouter(t)
+	r.GET("/:shortUrl/qr", LinkQRCode)
+	// The memory store would serve any name, only the handlers keep path
+	// input away from the keys of other backends.
+	require.NoError(t, store.SaveUrlMapping(context.Background(), &store.LinkRecord{
+		ShortUrl:    "seq:short_codes",
+		OriginalUrl: "https://example.com/internal",
+		UserId:      testUserId,
+	}))
+
+	for _, w := range []*httptest.ResponseRecorder{
+		perform(r, http.MethodGet, "/seq:short_codes", nil),
+		perform(r, http.MethodGet, "/seq:short_codes"+PreviewSuffix, nil),
+		perform(r, http.MethodGet, "/seq:short_codes/qr", nil),
+		visit(r, http.MethodPost, "/seq:short_codes/unlock", url.Values{"password": {"secret"}}),
+		perform(r, http.MethodGet, "/api/links/seq:short_codes/stats", nil),
+	} {
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, CodeNotFound, decodeError(t, w).Code)
+	}
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"go-url-shortener/store"
+)
+
+// shuttingDown makes Readyz fail while the server drains the requests in
+// flight.
+var shuttingDown atomic.Bool
+
+// BeginShutdown marks the server as no longer ready.
+func BeginShutdown() {
+	shuttingDown.Store(true)
+}
+
+// Healthz answers as long as the process serves requests, whatever the state
+// of the store.
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz answers 503 while the store backend cannot be reached or the server
+// is shutting down.
+func Readyz(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	if shuttingDown.Load() {
+		abortWithError(c, http.StatusServiceUnavailable, CodeServiceUnavailable, "server is shutting down")
+		return
+	}
+	if err := store.PingBackend(c.Request.Context()); err != nil {
+		slog.WarnContext(c.Request.Context(), "store backend not ready", "error", err)
+		abortWithError(c, http.StatusServiceUnavailable, CodeServiceUnavailable, "storage temporarily unavailable")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/store"
+)
+
+func newHealthRouter() *gin.Engine {
+	r := gin.New()
+	r.GET("/healthz", Healthz)
+	r.GET("/readyz", Readyz)
+	r.GET("/:shortUrl", HandleShortUrlRedirect)
+	return r
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	_, err := store.InitializeStore(store.Options{Backend: store.BackendRedis, RedisAddr: mr.Addr()})
+	require.NoError(t, err)
+	r := newHealthRouter()
+
+	assert.Equal(t, http.StatusOK, perform(r, http.MethodGet, "/healthz", nil).Code)
+	assert.Equal(t, http.StatusOK, perform(r, http.MethodGet, "/readyz", nil).Code)
+
+	mr.Close()
+	w := perform(r, http.MethodGet, "/readyz", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, ErrorResponse{Code: CodeServiceUnavailable, Message: "storage temporarily unavailable"}, decodeError(t, w),
+		"the cause is logged, not shown")
+	assert.Equal(t, http.StatusOK, perform(r, http.MethodGet, "/healthz", nil).Code, "liveness does not depend on the store")
+}
+
+func TestReadinessDuringShutdown(t *testing.T) {
+	newTestRouter(t)
+	r := newHealthRouter()
+	t.Cleanup(func() { shuttingDown.Store(false) })
+
+	BeginShutdown()
+	assert.Equal(t, http.StatusServiceUnavailable, perform(r, http.MethodGet, "/readyz", nil).Code)
+	assert.Equal(t, http.StatusOK, perform(r, http.MethodGet, "/healthz", nil).Code)
+}
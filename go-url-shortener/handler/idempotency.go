@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go-url-shortener/store"
+)
+
+const (
+	// IdempotencyKeyHeader lets clients retry a creation safely: within
+	// store.IdempotencyKeyTTL the same key returns the link of the first try.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// ReplayedHeader is set on responses answered from an earlier request.
+	ReplayedHeader = "Idempotent-Replayed"
+
+	MaxIdempotencyKeyLength = 255
+)
+
+// idempotencyFor returns the entry to look up and remember for the request,
+// nil when the client sent no Idempotency-Key. It reports false once it has
+// answered the request itself.
+func idempotencyFor(c *gin.Context, creationRequest UrlCreationRequest) (*store.IdempotencyEntry, bool) {
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" {
+		return nil, true
+	}
+	if len(key) > MaxIdempotencyKeyLength {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, IdempotencyKeyHeader+" must be at most "+strconv.Itoa(MaxIdempotencyKeyLength)+" characters")
+		return nil, false
+	}
+	data, err := json.Marshal(creationRequest)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		return nil, false
+	}
+	sum := sha256.Sum256(data)
+	return &store.IdempotencyEntry{
+		UserId:      creationRequest.UserId,
+		Key:         key,
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}, true
+}
+
+// replayIdempotent answers the request with the link an earlier request with
+// the same key created, reporting whether it did. A key sent again with a
+// different request is refused with 422.
+func replayIdempotent(c *gin.Context, idempotency *store.IdempotencyEntry) bool {
+	previous, err := store.RetrieveIdempotencyKey(c.Request.Context(), idempotency.UserId, idempotency.Key)
+	if errors.Is(err, store.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		abortWithStoreError(c, err)
+		return true
+	}
+	if previous.Fingerprint != idempotency.Fingerprint {
+		abortWithError(c, http.StatusUnprocessableEntity, CodeIdempotencyReused, IdempotencyKeyHeader+" was already used for a different request")
+		return true
+	}
+
+	record, err := store.RetrieveLink(c.Request.Context(), previous.ShortUrl)
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrExpired) {
+		// The link is gone since, create a new one.
+		return false
+	}
+	if err != nil {
+		abortWithStoreError(c, err)
+		return true
+	}
+	c.Header(ReplayedHeader, "true")
+	respondCreation(c, record, false)
+	return true
+}
+
+// rememberIdempotent stores which link the request produced. Failing to do so
+// only costs the client a deduplicated retry, so it does not fail the request.
+func rememberIdempotent(ctx context.Context, idempotency *store.IdempotencyEntry, record *store.LinkRecord) {
+	idempotency.ShortUrl = record.ShortUrl
+	if err := store.SaveIdempotencyKey(ctx, idempotency); err != nil {
+		slog.WarnContext(ctx, "remembering the idempotency key failed", "key", idempotency.Key, "error", err)
+	}
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/store"
+)
+
+func performIdempotent(r *gin.Engine, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/create-short-url", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(ApiKeyHeader, apiKeyFor(testUserId))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateShortUrlIdempotencyKey(t *testing.T) {
+	r := newTestRouter(t)
+	body := `{"long_url":"https://example.com/retry","custom_alias":"retry-me"}`
+
+	first := performIdempotent(r, "req-1", body)
+	require.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, true, decodeBody(t, first)["created"])
+	assert.Empty(t, first.Header().Get(ReplayedHeader))
+
+	retry := performIdempotent(r, "req-1", body)
+	require.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.Equal(t, false, decodeBody(t, retry)["created"])
+	assert.Equal(t, decodeBody(t, first)["short_url"], decodeBody(t, retry)["short_url"])
+
+	// The same key for another request is a client bug.
+	w := performIdempotent(r, "req-1", `{"long_url":"https://example.com/other"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, CodeIdempotencyReused, decodeError(t, w).Code)
+
+	// Once the link is deleted the key creates it anew.
+	require.NoError(t, store.DeleteUrlMapping(context.Background(), "retry-me"))
+	w = performIdempotent(r, "req-1", body)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, decodeBody(t, w)["created"])
+	assert.Empty(t, w.Header().Get(ReplayedHeader))
+
+	w = performIdempotent(r, strings.Repeat("k", MaxIdempotencyKeyLength+1), body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
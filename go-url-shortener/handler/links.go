@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-url-shortener/store"
+)
+
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
+)
+
+type LinkResponse struct {
+	Code              string     `json:"code"`
+	ShortUrl          string     `json:"short_url"`
+	LongUrl           string     `json:"long_url"`
+	UserId            string     `json:"user_id"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+	Title             string     `json:"title"`
+	Interstitial      bool       `json:"interstitial"`
+	RedirectStatus    int        `json:"redirect_status"`
+	PasswordProtected bool       `json:"password_protected"`
+	Tags              []string   `json:"tags"`
+	Disabled          bool       `json:"disabled"`
+	Clicks            int64      `json:"clicks"`
+}
+
+func newLinkResponse(record *store.LinkRecord) LinkResponse {
+	response := LinkResponse{
+		Code:              record.ShortUrl,
+		ShortUrl:          BaseUrl + record.ShortUrl,
+		LongUrl:           record.OriginalUrl,
+		UserId:            record.UserId,
+		CreatedAt:         record.CreatedAt,
+		Title:             record.Title,
+		Interstitial:      record.Interstitial,
+		RedirectStatus:    record.RedirectCode(),
+		PasswordProtected: record.PasswordHash != "",
+		Tags:              tagsJSON(record.Tags),
+		Disabled:          record.Disabled,
+		Clicks:            record.Clicks,
+	}
+	if !record.ExpiresAt.IsZero() {
+		expiresAt := record.ExpiresAt
+		response.ExpiresAt = &expiresAt
+	}
+	return response
+}
+
+type LinkListResponse struct {
+	Links   []LinkResponse `json:"links"`
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+	Total   int            `json:"total"`
+}
+
+// LinkUpdateRequest changes the target, the expiry, the title, the
+// interstitial mode, the redirect status, the password, the tags and/or the
+// disabled flag of a link; fields left out keep their current value and an
+// empty password removes the protection.
+type LinkUpdateRequest struct {
+	LongUrl        string    `json:"long_url,omitempty"`
+	Title          *string   `json:"title,omitempty"`
+	Interstitial   *bool     `json:"interstitial,omitempty"`
+	RedirectStatus *int      `json:"redirect_status,omitempty"`
+	Password       *string   `json:"password,omitempty"`
+	Tags           *[]string `json:"tags,omitempty"`
+	Disabled       *bool     `json:"disabled,omitempty"`
+	ExpiryPolicy
+}
+
+func (updateRequest LinkUpdateRequest) empty() bool {
+	return updateRequest.LongUrl == "" && updateRequest.Title == nil && updateRequest.Interstitial == nil &&
+		updateRequest.RedirectStatus == nil && updateRequest.Password == nil &&
+		updateRequest.Tags == nil && updateRequest.Disabled == nil && updateRequest.ExpiryPolicy.empty()
+}
+
+// callerId returns the user authenticated by RequireApiKey, answering the
+// request with 401 when there is none.
+func callerId(c *gin.Context) (string, bool) {
+	userId := c.GetString(userIdKey)
+	if userId == "" {
+		abortWithError(c, http.StatusUnauthorized, CodeUnauthorized, "missing API key")
+		return "", false
+	}
+	return userId, true
+}
+
+// ownedLink loads the link behind the :code parameter and checks it belongs to
+// the caller. Expired links that are still retained are returned too. It
+// reports false once it has answered the request itself.
+func ownedLink(c *gin.Context) (*store.LinkRecord, bool) {
+	userId, ok := callerId(c)
+	if !ok {
+		return nil, false
+	}
+	record, err := retrieveCode(c.Request.Context(), c.Param("code"))
+	if err != nil && !errors.Is(err, store.ErrExpired) {
+		abortWithStoreError(c, err)
+		return nil, false
+	}
+	if record.UserId != userId {
+		abortWithError(c, http.StatusForbidden, CodeForbidden, "link belongs to another user")
+		return nil, false
+	}
+	return record, true
+}
+
+func pagination(c *gin.Context) (int, int, bool) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, "page must be a positive integer")
+		return 0, 0, false
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(DefaultPerPage)))
+	if err != nil || perPage < 1 || perPage > MaxPerPage {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, "per_page must be between 1 and "+strconv.Itoa(MaxPerPage))
+		return 0, 0, false
+	}
+	return page, perPage, true
+}
+
+// ListUserLinks serves GET /api/users/:id/links, paginated with the page and
+// per_page query parameters. Users may only list their own links.
+func ListUserLinks(c *gin.Context) {
+	userId, ok := callerId(c)
+	if !ok {
+		return
+	}
+	if c.Param("id") != userId {
+		abortWithError(c, http.StatusForbidden, CodeForbidden, "cannot list another user's links")
+		return
+	}
+	page, perPage, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	shortUrls, err := store.ListUserUrls(c.Request.Context(), userId)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+
+	links := make([]LinkResponse, 0, perPage)
+	start := (page - 1) * perPage
+	for i := start; i < len(shortUrls) && i < start+perPage; i++ {
+		record, err := store.RetrieveLink(c.Request.Context(), shortUrls[i])
+		if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrExpired) {
+			// Expired between listing and loading it.
+			continue
+		}
+		if err != nil {
+			abortWithStoreError(c, err)
+			return
+		}
+		links = append(links, newLinkResponse(record))
+	}
+
+	c.JSON(http.StatusOK, LinkListResponse{
+		Links:   links,
+		Page:    page,
+		PerPage: perPage,
+		Total:   len(shortUrls),
+	})
+}
+
+// UpdateLink serves PATCH /api/links/:code.
+func UpdateLink(c *gin.Context) {
+	var updateRequest LinkUpdateRequest
+	if err := c.ShouldBindJSON(&updateRequest); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if updateRequest.empty() {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, "nothing to update")
+		return
+	}
+	if updateRequest.Title != nil {
+		if err := checkTitle(*updateRequest.Title); err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+	}
+	if updateRequest.RedirectStatus != nil {
+		if err := checkRedirectStatus(*updateRequest.RedirectStatus); err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidRedirect, err.Error())
+			return
+		}
+	}
+	if updateRequest.Tags != nil {
+		tags, err := normalizeTags(*updateRequest.Tags)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+		updateRequest.Tags = &tags
+	}
+
+	if updateRequest.LongUrl != "" {
+		var ok bool
+		if updateRequest.LongUrl, ok = normalizeTarget(c, updateRequest.LongUrl); !ok {
+			return
+		}
+	}
+
+	record, ok := ownedLink(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if record.Expired(now) {
+		abortWithStoreError(c, store.ErrExpired)
+		return
+	}
+
+	if updateRequest.LongUrl != "" {
+		record.OriginalUrl = updateRequest.LongUrl
+	}
+	if updateRequest.Title != nil {
+		record.Title = *updateRequest.Title
+	}
+	if updateRequest.Interstitial != nil {
+		record.Interstitial = *updateRequest.Interstitial
+	}
+	if updateRequest.RedirectStatus != nil {
+		record.RedirectStatus = *updateRequest.RedirectStatus
+	}
+	if updateRequest.Password != nil && !hashPassword(c, record, *updateRequest.Password) {
+		return
+	}
+	if updateRequest.Tags != nil {
+		record.Tags = *updateRequest.Tags
+	}
+	if updateRequest.Disabled != nil {
+		record.Disabled = *updateRequest.Disabled
+	}
+	if !updateRequest.ExpiryPolicy.empty() {
+		var err error
+		record.ExpiresAt, err = updateRequest.ExpiryPolicy.resolve(now, 0)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidExpiry, err.Error())
+			return
+		}
+	}
+	if err := store.UpdateUrlMapping(c.Request.Context(), record); err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newLinkResponse(record))
+}
+
+// DeleteLink serves DELETE /api/links/:code. Expired links that are still
+// retained can be deleted as well.
+func DeleteLink(c *gin.Context) {
+	record, ok := ownedLink(c)
+	if !ok {
+		return
+	}
+	if err := store.DeleteUrlMapping(c.Request.Context(), record.ShortUrl); err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
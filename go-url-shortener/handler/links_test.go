@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/store"
+)
+
+func performAs(r http.Handler, userId string, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if userId != "" {
+		req.Header.Set(ApiKeyHeader, apiKeyFor(userId))
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func newManagementRouter(t *testing.T) *gin.Engine {
+	r := newTestRouter(t)
+	r.GET("/api/users/:id/links", RequireApiKey, ListUserLinks)
+	r.PATCH("/api/links/:code", RequireApiKey, UpdateLink)
+	r.DELETE("/api/links/:code", RequireApiKey, DeleteLink)
+	return r
+}
+
+func saveLinks(t *testing.T, userId string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{
+			ShortUrl:    fmt.Sprintf("%s-%02d", userId, i),
+			OriginalUrl: fmt.Sprintf("https://example.com/%d", i),
+			UserId:      userId,
+			CreatedAt:   time.Now(),
+		}))
+	}
+}
+
+func TestListUserLinks(t *testing.T) {
+	r := newManagementRouter(t)
+	saveLinks(t, "alice", 5)
+	saveLinks(t, "bob", 1)
+
+	w := performAs(r, "alice", http.MethodGet, "/api/users/alice/links?page=2&per_page=2", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp LinkListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 5, resp.Total)
+	assert.Equal(t, 2, resp.Page)
+	require.Len(t, resp.Links, 2)
+	assert.Equal(t, "alice-02", resp.Links[0].Code)
+	assert.Equal(t, BaseUrl+"alice-02", resp.Links[0].ShortUrl)
+	assert.Equal(t, "alice", resp.Links[0].UserId)
+
+	w = performAs(r, "alice", http.MethodGet, "/api/users/alice/links?page=9", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Empty(t, resp.Links)
+
+	w = performAs(r, "alice", http.MethodGet, "/api/users/bob/links", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performAs(r, "", http.MethodGet, "/api/users/alice/links", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = performAs(r, "alice", http.MethodGet, "/api/users/alice/links?per_page=1000", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestUpdateLink(t *testing.T) {
+	r := newManagementRouter(t)
+	saveLinks(t, "carol", 1)
+
+	w := performAs(r, "carol", http.MethodPatch, "/api/links/carol-00", `{"long_url":"https://example.com/new","ttl":60}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp LinkResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "https://example.com/new", resp.LongUrl)
+	require.NotNil(t, resp.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *resp.ExpiresAt, 5*time.Second)
+
+	longUrl, err := store.RetrieveInitialUrl(context.Background(), "carol-00")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/new", longUrl)
+
+	w = performAs(r, "carol", http.MethodPatch, "/api/links/carol-00", `{"never_expires":true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Nil(t, resp.ExpiresAt)
+
+	w = performAs(r, "carol", http.MethodPatch, "/api/links/carol-00", `{"title":"New home","interstitial":true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "New home", resp.Title)
+	assert.True(t, resp.Interstitial)
+	assert.Equal(t, "https://example.com/new", resp.LongUrl, "fields left out are kept")
+
+	w = performAs(r, "carol", http.MethodPatch, "/api/links/carol-00", `{"redirect_status":308}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusPermanentRedirect, resp.RedirectStatus)
+
+	w = performAs(r, "carol", http.MethodPatch, "/api/links/carol-00", `{"redirect_status":303}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performAs(r, "mallory", http.MethodPatch, "/api/links/carol-00", `{"long_url":"https://evil.example"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performAs(r, "carol", http.MethodPatch, "/api/links/carol-00", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performAs(r, "carol", http.MethodPatch, "/api/links/nope", `{"long_url":"https://example.com"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestLinkTagsAndDisabled(t *testing.T) {
+	r := newManagementRouter(t)
+	w := performAs(r, "dave", http.MethodPost, "/create-short-url", `{"long_url":"https://example.com/tagged","tags":[" launch ","email","launch"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	created := decodeBody(t, w)
+	assert.Equal(t, []interface{}{"launch", "email"}, created["tags"])
+	code := created["code"].(string)
+
+	w = performAs(r, "dave", http.MethodGet, "/"+code, "")
+	require.Equal(t, http.StatusFound, w.Code)
+
+	w = performAs(r, "dave", http.MethodPatch, "/api/links/"+code, `{"disabled":true,"tags":[]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp LinkResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.Disabled)
+	assert.Empty(t, resp.Tags)
+
+	w = performAs(r, "dave", http.MethodGet, "/"+code, "")
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Equal(t, CodeDisabled, decodeError(t, w).Code)
+
+	w = performAs(r, "dave", http.MethodPatch, "/api/links/"+code, `{"disabled":false}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = performAs(r, "dave", http.MethodGet, "/"+code, "")
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	w = performAs(r, "dave", http.MethodPost, "/create-short-url", `{"long_url":"https://example.com","tags":[""]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDeleteLink(t *testing.T) {
+	r := newManagementRouter(t)
+	saveLinks(t, "dave", 1)
+
+	w := performAs(r, "eve", http.MethodDelete, "/api/links/dave-00", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performAs(r, "dave", http.MethodDelete, "/api/links/dave-00", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	_, err := store.RetrieveLink(context.Background(), "dave-00")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	w = performAs(r, "dave", http.MethodDelete, "/api/links/dave-00", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/logging"
+	"go-url-shortener/store"
+)
+
+// captureLogs makes the default logger write JSON lines to the returned
+// buffer for the rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var out bytes.Buffer
+	logger, err := logging.New(&out, logging.Options{Level: "debug", Format: logging.FormatJSON})
+	require.NoError(t, err)
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &out
+}
+
+func logLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	out := captureLogs(t)
+	r := gin.New()
+	r.Use(RequestID, AccessLog)
+	r.GET("/echo/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, logging.RequestID(c.Request.Context()))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/echo/1", nil)
+	req.Header.Set(RequestIDHeader, "upstream-42")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "upstream-42", w.Body.String())
+	assert.Equal(t, "upstream-42", w.Header().Get(RequestIDHeader))
+
+	req = httptest.NewRequest(http.MethodGet, "/echo/2", nil)
+	req.Header.Set(RequestIDHeader, "not a usable id\n")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	generated := w.Header().Get(RequestIDHeader)
+	assert.Len(t, generated, 32)
+	assert.Equal(t, generated, w.Body.String())
+
+	lines := logLines(t, out)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "request served", lines[0]["msg"])
+	assert.Equal(t, "upstream-42", lines[0]["request_id"])
+	assert.Equal(t, "/echo/:id", lines[0]["route"])
+	assert.Equal(t, "/echo/1", lines[0]["path"])
+	assert.EqualValues(t, http.StatusOK, lines[0]["status"])
+	assert.Equal(t, generated, lines[1]["request_id"])
+}
+
+func TestStoreLogsCarryRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	out := captureLogs(t)
+	_, err := store.InitializeStore(store.Options{Backend: store.BackendRedis, RedisAddr: mr.Addr()})
+	require.NoError(t, err)
+	r := gin.New()
+	r.Use(RequestID, AccessLog)
+	r.GET("/:shortUrl", HandleShortUrlRedirect)
+
+	mr.Close()
+	req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+	req.Header.Set(RequestIDHeader, "failing-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	var messages []string
+	for _, line := range logLines(t, out) {
+		if line["request_id"] == "failing-1" {
+			messages = append(messages, line["msg"].(string))
+		}
+	}
+	assert.Equal(t, []string{"store operation failed", "request served"}, messages)
+}
+
+func TestUnexpectedErrorsAreLoggedNotShown(t *testing.T) {
+	out := captureLogs(t)
+	ctx := logging.WithRequestID(context.Background(), "failing-2")
+
+	status, response := storeError(ctx, errors.New("disk quota exceeded on /var/lib/shortener"))
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, ErrorResponse{Code: CodeInternal, Message: "internal error"}, response)
+
+	lines := logLines(t, out)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "request failed", lines[0]["msg"])
+	assert.Equal(t, "failing-2", lines[0]["request_id"])
+	assert.Equal(t, "disk quota exceeded on /var/lib/shortener", lines[0]["error"])
+}
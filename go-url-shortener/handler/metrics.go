@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go-url-shortener/store"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shortener_http_requests_total",
+		Help: "HTTP requests served, by route and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shortener_http_request_duration_seconds",
+		Help:    "Latency of the HTTP requests served, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	redirects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shortener_redirects_total",
+		Help: "Short urls followed, by outcome: hit, miss, expired, disabled, locked or error.",
+	}, []string{"result"})
+	linksCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shortener_links_created_total",
+		Help: "Links created, by endpoint, and existing links handed out again instead.",
+	}, []string{"source", "result"})
+)
+
+// Outcomes of a redirect counted by shortener_redirects_total.
+const (
+	redirectHit      = "hit"
+	redirectMiss     = "miss"
+	redirectExpired  = "expired"
+	redirectDisabled = "disabled"
+	redirectLocked   = "locked"
+	redirectError    = "error"
+)
+
+// redirectFailure is the outcome of a redirect whose link could not be read.
+func redirectFailure(err error) string {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return redirectMiss
+	case errors.Is(err, store.ErrExpired):
+		return redirectExpired
+	default:
+		return redirectError
+	}
+}
+
+// unmatchedRoute labels the requests no route matched, keeping arbitrary
+// paths out of the metric labels.
+const unmatchedRoute = "unmatched"
+
+// Metrics records the count and latency of the requests it wraps, labelled
+// by route pattern rather than path.
+func Metrics(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	route := c.FullPath()
+	if route == "" {
+		route = unmatchedRoute
+	}
+	method := c.Request.Method
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+}
+
+// MetricsEndpoint serves the metrics in the Prometheus text format.
+func MetricsEndpoint() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// countCreation counts a link handed out by source.
+func countCreation(source string, created bool) {
+	result := "created"
+	if !created {
+		result = "reused"
+	}
+	linksCreated.WithLabelValues(source, result).Inc()
+}
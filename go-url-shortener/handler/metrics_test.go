@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/store"
+)
+
+func TestMetrics(t *testing.T) {
+	r := newTestRouter(t)
+	_, err := store.InitializeStore(store.Options{Backend: store.BackendMemory, CacheSize: 10, CacheTTL: time.Minute})
+	require.NoError(t, err)
+	r.Use(Metrics)
+	r.GET("/metrics", MetricsEndpoint())
+	r.POST("/metered", RequireApiKey, CreateShortUrl)
+	r.GET("/metered/:shortUrl", HandleShortUrlRedirect)
+
+	created := testutil.ToFloat64(linksCreated.WithLabelValues("api", "created"))
+	reused := testutil.ToFloat64(linksCreated.WithLabelValues("api", "reused"))
+	hits := testutil.ToFloat64(redirects.WithLabelValues(redirectHit))
+	misses := testutil.ToFloat64(redirects.WithLabelValues(redirectMiss))
+	requests := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/metered/:shortUrl", "302"))
+
+	w := performAs(r, testUserId, http.MethodPost, "/metered", `{"long_url": "https://example.com/metered"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	code := decodeBody(t, w)["code"].(string)
+	performAs(r, testUserId, http.MethodPost, "/metered", `{"long_url": "https://example.com/metered"}`)
+	assert.Equal(t, created+1, testutil.ToFloat64(linksCreated.WithLabelValues("api", "created")))
+	assert.Equal(t, reused+1, testutil.ToFloat64(linksCreated.WithLabelValues("api", "reused")))
+
+	assert.Equal(t, http.StatusFound, perform(r, http.MethodGet, "/metered/"+code, nil).Code)
+	assert.Equal(t, http.StatusFound, perform(r, http.MethodGet, "/metered/"+code, nil).Code)
+	assert.Equal(t, http.StatusNotFound, perform(r, http.MethodGet, "/metered/unknown", nil).Code)
+	assert.Equal(t, hits+2, testutil.ToFloat64(redirects.WithLabelValues(redirectHit)))
+	assert.Equal(t, misses+1, testutil.ToFloat64(redirects.WithLabelValues(redirectMiss)))
+	assert.Equal(t, requests+2, testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/metered/:shortUrl", "302")),
+		"requests are labelled by route, not path")
+
+	w = perform(r, http.MethodGet, "/metrics", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	for _, metric := range []string{
+		"shortener_http_request_duration_seconds_bucket",
+		"shortener_redirects_total",
+		"shortener_links_created_total",
+		"shortener_store_operation_duration_seconds_bucket",
+		"shortener_cache_hits_total 1",
+		"shortener_cache_entries",
+	} {
+		assert.Contains(t, w.Body.String(), metric)
+	}
+	assert.False(t, strings.Contains(w.Body.String(), `route="/metered/`+code+`"`))
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"go-url-shortener/store"
+)
+
+const (
+	// PreviewSuffix appended to a short url shows the link instead of
+	// following it, e.g. /jTa4L57P+.
+	PreviewSuffix  = "+"
+	MaxTitleLength = 200
+	MaxTags        = 20
+	MaxTagLength   = 50
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var pages = template.Must(template.ParseFS(templateFiles, "templates/*.html"))
+
+func checkTitle(title string) error {
+	if utf8.RuneCountInString(title) > MaxTitleLength {
+		return fmt.Errorf("title must be at most %d characters", MaxTitleLength)
+	}
+	return nil
+}
+
+// normalizeTags trims tags and drops repeated ones, failing on empty or
+// oversized tags or too many of them.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	normalized := make([]string, 0, len(tags))
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || utf8.RuneCountInString(tag) > MaxTagLength {
+			return nil, fmt.Errorf("tags must be between 1 and %d characters", MaxTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > MaxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", MaxTags)
+	}
+	return normalized, nil
+}
+
+// PublicLinkResponse is what visitors may learn of a link, leaving out its
+// owner and everything else only the owner sees.
+type PublicLinkResponse struct {
+	ShortUrl  string     `json:"short_url"`
+	LongUrl   string     `json:"long_url"`
+	Title     string     `json:"title"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func newPublicLinkResponse(record *store.LinkRecord) PublicLinkResponse {
+	response := PublicLinkResponse{
+		ShortUrl: BaseUrl + record.ShortUrl,
+		LongUrl:  record.OriginalUrl,
+		Title:    record.Title,
+	}
+	if !record.ExpiresAt.IsZero() {
+		expiresAt := record.ExpiresAt
+		response.ExpiresAt = &expiresAt
+	}
+	return response
+}
+
+// pageData is what the interstitial and preview pages render.
+type pageData struct {
+	PublicLinkResponse
+	Host string
+}
+
+func newPageData(record *store.LinkRecord) pageData {
+	data := pageData{PublicLinkResponse: newPublicLinkResponse(record)}
+	if target, err := url.Parse(record.OriginalUrl); err == nil {
+		data.Host = target.Hostname()
+	}
+	return data
+}
+
+func renderPage(c *gin.Context, name string, record *store.LinkRecord) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Render(http.StatusOK, render.HTML{Template: pages, Name: name, Data: newPageData(record)})
+}
+
+// previewLink describes the link as HTML, or as JSON to clients preferring
+// it, without following it or counting a click. Protected links are only
+// previewed once unlocked.
+func previewLink(c *gin.Context, shortUrl string) {
+	record, err := retrieveCode(c.Request.Context(), shortUrl)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	if !requireLinkAccess(c, record, true) {
+		return
+	}
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, newPublicLinkResponse(record))
+		return
+	}
+	renderPage(c, "preview.html", record)
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/store"
+)
+
+func TestRedirectInterstitial(t *testing.T) {
+	r := newTestRouter(t)
+	w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{
+		LongUrl:      "https://docs.example.com/report?q=<b>",
+		Title:        "Q3 <report>",
+		Interstitial: true,
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	created := decodeBody(t, w)
+	assert.Equal(t, true, created["interstitial"])
+	assert.Equal(t, "Q3 <report>", created["title"])
+
+	w = perform(r, http.MethodGet, "/"+created["code"].(string), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/html"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	page := w.Body.String()
+	assert.Contains(t, page, "docs.example.com")
+	assert.Contains(t, page, "Q3 &lt;report&gt;")
+	assert.Contains(t, page, `href="https://docs.example.com/report?q=%3cb%3e"`)
+	assert.NotContains(t, page, "<report>")
+}
+
+func TestRedirectWithoutInterstitial(t *testing.T) {
+	r := newTestRouter(t)
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{ShortUrl: "plain1", OriginalUrl: "https://example.com", UserId: testUserId}))
+
+	w := perform(r, http.MethodGet, "/plain1", nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com", w.Header().Get("Location"))
+}
+
+func TestPreviewLink(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRouter(t)
+	require.NoError(t, store.CreateUrlMapping(ctx, &store.LinkRecord{
+		ShortUrl:    "prev1",
+		OriginalUrl: "https://example.com/landing",
+		UserId:      testUserId,
+		Title:       "Landing page",
+	}))
+
+	w := perform(r, http.MethodGet, "/prev1+", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/html"))
+	assert.Contains(t, w.Body.String(), "Landing page")
+	assert.Contains(t, w.Body.String(), "https://example.com/landing")
+	assert.Contains(t, w.Body.String(), "never")
+
+	req := httptest.NewRequest(http.MethodGet, "/prev1+", nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, map[string]interface{}{
+		"short_url":  BaseUrl + "prev1",
+		"long_url":   "https://example.com/landing",
+		"title":      "Landing page",
+		"expires_at": nil,
+	}, resp, "nothing of the owner is disclosed")
+
+	// Previews are not clicks.
+	stats, err := store.RetrieveClickStats(ctx, "prev1")
+	require.NoError(t, err)
+	assert.Zero(t, stats.Total)
+
+	w = perform(r, http.MethodGet, "/missing+", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCreateShortUrlPresentationNotReused(t *testing.T) {
+	r := newTestRouter(t)
+	longUrl := "https://example.com/presented"
+
+	plain := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: longUrl})
+	require.Equal(t, http.StatusOK, plain.Code)
+	guarded := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: longUrl, Interstitial: true})
+	require.Equal(t, http.StatusOK, guarded.Code)
+
+	assert.Equal(t, true, decodeBody(t, guarded)["created"])
+	assert.NotEqual(t, decodeBody(t, plain)["code"], decodeBody(t, guarded)["code"])
+
+	again := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: longUrl, Interstitial: true})
+	require.Equal(t, http.StatusOK, again.Code)
+	assert.Equal(t, false, decodeBody(t, again)["created"])
+	assert.Equal(t, decodeBody(t, guarded)["code"], decodeBody(t, again)["code"])
+}
+
+func TestCreateShortUrlTitleTooLong(t *testing.T) {
+	r := newTestRouter(t)
+	w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{
+		LongUrl: "https://example.com",
+		Title:   strings.Repeat("t", MaxTitleLength+1),
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"go-url-shortener/auth"
+	"go-url-shortener/store"
+)
+
+const linkAccessCookiePrefix = "link_access_"
+
+var (
+	// LinkAccessSecret signs the cookies of visitors who unlocked a password
+	// protected link. The random default does not survive restarts nor is it
+	// shared between instances.
+	LinkAccessSecret = randomSecret()
+	// LinkAccessTTL is how long an unlocked link stays unlocked.
+	LinkAccessTTL = time.Hour
+	// UnlockRateLimit slows down password guessing, per client IP.
+	UnlockRateLimit = store.RateLimit{Rate: 0.2, Burst: 10}
+)
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// passwordForm is what the password page renders.
+type passwordForm struct {
+	Action  string
+	Preview bool
+	Failed  bool
+}
+
+// hashPassword fills the password hash of record, answering the request
+// itself when the password is unusable.
+func hashPassword(c *gin.Context, record *store.LinkRecord, password string) bool {
+	if password == "" {
+		record.PasswordHash = ""
+		return true
+	}
+	hash, err := auth.HashLinkPassword(password)
+	if errors.Is(err, auth.ErrInvalidPassword) {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidPassword, err.Error())
+		return false
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		return false
+	}
+	record.PasswordHash = hash
+	return true
+}
+
+// requireLinkAccess lets the request through to record when it is not
+// password protected or the visitor unlocked it already. Otherwise browsers
+// get the password form, anything else 401.
+func requireLinkAccess(c *gin.Context, record *store.LinkRecord, preview bool) bool {
+	if record.PasswordHash == "" {
+		return true
+	}
+	if token, err := c.Cookie(linkAccessCookiePrefix + record.ShortUrl); err == nil &&
+		auth.VerifyLinkAccess(LinkAccessSecret, record, token, time.Now()) == nil {
+		return true
+	}
+	if c.Request.Method != http.MethodGet {
+		abortWithError(c, http.StatusUnauthorized, CodeUnauthorized, "link is password protected")
+		return false
+	}
+	renderPasswordForm(c, http.StatusOK, record, preview, false)
+	return false
+}
+
+func renderPasswordForm(c *gin.Context, status int, record *store.LinkRecord, preview bool, failed bool) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Render(status, render.HTML{Template: pages, Name: "password.html", Data: passwordForm{
+		Action:  BaseUrl + record.ShortUrl + "/unlock",
+		Preview: preview,
+		Failed:  failed,
+	}})
+	c.Abort()
+}
+
+// UnlockLink serves POST /:shortUrl/unlock, the target of the password form.
+// The right password earns a cookie opening the link for LinkAccessTTL and a
+// redirect back to it, or to its preview.
+func UnlockLink(c *gin.Context) {
+	record, err := retrieveCode(c.Request.Context(), c.Param("shortUrl"))
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	preview := c.PostForm("preview") != ""
+	if !auth.CheckLinkPassword(record, c.PostForm("password")) {
+		renderPasswordForm(c, http.StatusUnauthorized, record, preview, true)
+		return
+	}
+
+	if record.PasswordHash != "" {
+		expiresAt := time.Now().Add(LinkAccessTTL)
+		if !record.ExpiresAt.IsZero() && record.ExpiresAt.Before(expiresAt) {
+			expiresAt = record.ExpiresAt
+		}
+		path, secure := "/", false
+		if base, err := url.Parse(BaseUrl); err == nil {
+			path, secure = base.Path, base.Scheme == "https"
+		}
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     linkAccessCookiePrefix + record.ShortUrl,
+			Value:    auth.SignLinkAccess(LinkAccessSecret, record, expiresAt),
+			Path:     path,
+			Expires:  expiresAt,
+			MaxAge:   int(time.Until(expiresAt).Seconds()),
+			Secure:   secure,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	location := BaseUrl + record.ShortUrl
+	if preview {
+		location += PreviewSuffix
+	}
+	c.Redirect(http.StatusSeeOther, location)
+}
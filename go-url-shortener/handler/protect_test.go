@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newProtectRouter(t *testing.T) *gin.Engine {
+	auth.LinkPasswordCost = bcrypt.MinCost
+	r := newManagementRouter(t)
+	r.POST("/:shortUrl", HandleShortUrlRedirect)
+	r.POST("/:shortUrl/unlock", UnlockLink)
+	return r
+}
+
+func visit(r http.Handler, method string, path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	var body *strings.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	} else {
+		body = strings.NewReader("")
+	}
+	req := httptest.NewRequest(method, path, body)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func createProtected(t *testing.T, r http.Handler, password string) string {
+	t.Helper()
+	w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: "https://example.com/secret", Password: password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	created := decodeBody(t, w)
+	assert.Equal(t, true, created["password_protected"])
+	return created["code"].(string)
+}
+
+func TestPasswordProtectedLink(t *testing.T) {
+	r := newProtectRouter(t)
+	code := createProtected(t, r, "hunter22")
+
+	w := visit(r, http.MethodGet, "/"+code, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+	assert.Contains(t, w.Body.String(), `action="`+BaseUrl+code+`/unlock"`)
+	assert.NotContains(t, w.Body.String(), "example.com/secret")
+
+	w = visit(r, http.MethodPost, "/"+code+"/unlock", url.Values{"password": {"hunter2"}})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "Wrong password")
+	assert.Empty(t, w.Result().Cookies())
+
+	w = visit(r, http.MethodPost, "/"+code+"/unlock", url.Values{"password": {"hunter22"}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, BaseUrl+code, w.Header().Get("Location"))
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.True(t, cookies[0].HttpOnly)
+
+	w = visit(r, http.MethodGet, "/"+code, nil, cookies...)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/secret", w.Header().Get("Location"))
+
+	// The cookie of one link does not open another.
+	other := createProtected(t, r, "hunter22")
+	forged := &http.Cookie{Name: linkAccessCookiePrefix + other, Value: cookies[0].Value}
+	w = visit(r, http.MethodGet, "/"+other, nil, forged)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPasswordProtectedPermanentRedirectNotCached(t *testing.T) {
+	r := newProtectRouter(t)
+	w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{
+		LongUrl:        "https://example.com/secret",
+		Password:       "hunter22",
+		RedirectStatus: http.StatusMovedPermanently,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	code := decodeBody(t, w)["code"].(string)
+
+	w = visit(r, http.MethodPost, "/"+code+"/unlock", url.Values{"password": {"hunter22"}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	w = visit(r, http.MethodGet, "/"+code, nil, w.Result().Cookies()...)
+	require.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "https://example.com/secret", w.Header().Get("Location"))
+	assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+}
+
+func TestPasswordProtectedPreview(t *testing.T) {
+	r := newProtectRouter(t)
+	code := createProtected(t, r, "hunter22")
+
+	w := visit(r, http.MethodGet, "/"+code+PreviewSuffix, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "example.com/secret")
+	assert.Contains(t, w.Body.String(), `name="preview"`)
+
+	w = visit(r, http.MethodPost, "/"+code+"/unlock", url.Values{"password": {"hunter22"}, "preview": {"1"}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, BaseUrl+code+PreviewSuffix, w.Header().Get("Location"))
+
+	w = visit(r, http.MethodGet, "/"+code+PreviewSuffix, nil, w.Result().Cookies()...)
+	assert.Contains(t, w.Body.String(), "example.com/secret")
+}
+
+func TestPasswordProtectedNonGet(t *testing.T) {
+	r := newProtectRouter(t)
+	code := createProtected(t, r, "hunter22")
+
+	w := visit(r, http.MethodPost, "/"+code, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, CodeUnauthorized, decodeError(t, w).Code)
+}
+
+func TestPasswordChangeRevokesAccess(t *testing.T) {
+	r := newProtectRouter(t)
+	code := createProtected(t, r, "hunter22")
+	w := visit(r, http.MethodPost, "/"+code+"/unlock", url.Values{"password": {"hunter22"}})
+	cookies := w.Result().Cookies()
+
+	w = performAs(r, testUserId, http.MethodPatch, "/api/links/"+code, `{"password":"correct horse"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = visit(r, http.MethodGet, "/"+code, nil, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code, "the password form again")
+
+	w = performAs(r, testUserId, http.MethodPatch, "/api/links/"+code, `{"password":""}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, false, decodeBody(t, w)["password_protected"])
+	w = visit(r, http.MethodGet, "/"+code, nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+}
+
+func TestCreateShortUrlInvalidPassword(t *testing.T) {
+	r := newProtectRouter(t)
+	w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: "https://example.com", Password: "abc"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CodeInvalidPassword, decodeError(t, w).Code)
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-url-shortener/qr"
+)
+
+const (
+	DefaultQRSize = 256
+	MinQRSize     = 64
+	MaxQRSize     = 2048
+	// QRMaxAge bounds how long clients may cache a QR code; the image itself
+	// only changes with the base url.
+	QRMaxAge = 24 * time.Hour
+)
+
+var qrContentTypes = map[string]string{
+	"png": "image/png",
+	"svg": "image/svg+xml",
+}
+
+// LinkQRCode serves GET /:shortUrl/qr, a QR code of the full short url. The
+// format (png or svg), size in pixels, level (L, M, Q or H error correction)
+// and margin in modules query parameters shape the image.
+func LinkQRCode(c *gin.Context) {
+	shortUrl := c.Param("shortUrl")
+
+	format := strings.ToLower(c.DefaultQuery("format", "png"))
+	contentType, ok := qrContentTypes[format]
+	if !ok {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, "format must be \"png\" or \"svg\"")
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(DefaultQRSize)))
+	if err != nil || size < MinQRSize || size > MaxQRSize {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("size must be between %d and %d", MinQRSize, MaxQRSize))
+		return
+	}
+	level := strings.ToUpper(c.DefaultQuery("level", "M"))
+	margin, err := strconv.Atoi(c.DefaultQuery("margin", strconv.Itoa(qr.DefaultMargin)))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, qr.ErrMargin.Error())
+		return
+	}
+
+	record, err := retrieveCode(c.Request.Context(), shortUrl)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+
+	content := BaseUrl + record.ShortUrl
+	etag := qrETag(content, format, size, level, margin)
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cacheMaxAge(record, time.Now(), QRMaxAge).Seconds())))
+	c.Header("ETag", etag)
+	if match := c.GetHeader("If-None-Match"); match != "" && (match == etag || match == "*") {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	code, err := qr.Encode(content, level, margin)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	var image []byte
+	if format == "svg" {
+		image, err = code.SVG(size)
+	} else {
+		image, err = code.PNG(size)
+	}
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, contentType, image)
+}
+
+func qrETag(content string, format string, size int, level string, margin int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%s\x00%d", content, format, size, level, margin)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/store"
+)
+
+func newQRRouter(t *testing.T) *gin.Engine {
+	r := newTestRouter(t)
+	r.GET("/:shortUrl/qr", LinkQRCode)
+	return r
+}
+
+func TestLinkQRCodePNG(t *testing.T) {
+	r := newQRRouter(t)
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{ShortUrl: "qr1", OriginalUrl: "https://example.com", UserId: testUserId}))
+
+	w := perform(r, http.MethodGet, "/qr1/qr?size=300", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=86400", w.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+
+	img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 300, img.Bounds().Dx())
+}
+
+func TestLinkQRCodeSVG(t *testing.T) {
+	r := newQRRouter(t)
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{ShortUrl: "qr2", OriginalUrl: "https://example.com", UserId: testUserId}))
+
+	w := perform(r, http.MethodGet, "/qr2/qr?format=svg&level=H&margin=0", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+	assert.True(t, strings.Contains(w.Body.String(), "<svg"))
+}
+
+func TestLinkQRCodeConditional(t *testing.T) {
+	r := newQRRouter(t)
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{ShortUrl: "qr3", OriginalUrl: "https://example.com", UserId: testUserId}))
+
+	w := perform(r, http.MethodGet, "/qr3/qr", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+
+	req := httptest.NewRequest(http.MethodGet, "/qr3/qr", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+
+	// Another rendering has another tag.
+	w = perform(r, http.MethodGet, "/qr3/qr?size=512", nil)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
+
+func TestLinkQRCodeExpiringLinkCache(t *testing.T) {
+	r := newQRRouter(t)
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{
+		ShortUrl:    "qr4",
+		OriginalUrl: "https://example.com",
+		UserId:      testUserId,
+		ExpiresAt:   time.Now().Add(time.Hour + 30*time.Second),
+	}))
+
+	w := perform(r, http.MethodGet, "/qr4/qr", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, []string{"public, max-age=3629", "public, max-age=3630"}, w.Header().Get("Cache-Control"))
+}
+
+func TestLinkQRCodeErrors(t *testing.T) {
+	r := newQRRouter(t)
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{ShortUrl: "qr5", OriginalUrl: "https://example.com", UserId: testUserId}))
+
+	w := perform(r, http.MethodGet, "/missing/qr", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeNotFound, decodeError(t, w).Code)
+
+	for _, query := range []string{"format=gif", "size=10", "size=big", "level=X", "margin=99", "margin=-1"} {
+		w = perform(r, http.MethodGet, "/qr5/qr?"+query, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go-url-shortener/store"
+)
+
+// Link creation is limited per API key, redirects per client IP. Bulk
+// creation has a quota of its own, each row taking a token.
+var (
+	CreateRateLimit   = store.RateLimit{Rate: 1, Burst: 20}
+	RedirectRateLimit = store.RateLimit{Rate: 50, Burst: 200}
+	BulkRateLimit     = store.RateLimit{Rate: 2, Burst: 10000}
+)
+
+// rateLimitSubject identifies who a request is counted against: the API key
+// authenticated by RequireApiKey when there is one, the client IP otherwise.
+func rateLimitSubject(c *gin.Context) string {
+	if id := c.GetString(apiKeyIdKey); id != "" {
+		return "key:" + id
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// RateLimit answers 429 with a Retry-After header once the subject of the
+// request has used up its tokens for scope. The limit is read on every request
+// so it may be configured after the route is registered. Requests are let
+// through when the store cannot be reached.
+func RateLimit(scope string, limit *store.RateLimit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if takeTokens(c, scope, *limit, 1) {
+			c.Next()
+		}
+	}
+}
+
+// takeTokens charges n tokens of scope to the subject of the request,
+// answering it with 429 and reporting false when they are not all left.
+func takeTokens(c *gin.Context, scope string, limit store.RateLimit, n int) bool {
+	if limit.Disabled() {
+		return true
+	}
+	ok, wait, err := store.TakeTokens(c.Request.Context(), scope+":"+rateLimitSubject(c), n, limit)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "rate limit unavailable, letting the request through", "scope", scope, "error", err)
+		return true
+	}
+	if !ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		abortWithError(c, http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded, retry later")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/store"
+)
+
+func TestRateLimitCreatePerApiKey(t *testing.T) {
+	r := newTestRouter(t)
+	limit := store.RateLimit{Rate: 0.5, Burst: 2}
+	r.POST("/limited", RequireApiKey, RateLimit("create", &limit), CreateShortUrl)
+
+	body := `{"long_url":"https://example.com/limited"}`
+	for i := 0; i < limit.Burst; i++ {
+		w := performAs(r, "ivan", http.MethodPost, "/limited", body)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	w := performAs(r, "ivan", http.MethodPost, "/limited", body)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, CodeRateLimited, decodeError(t, w).Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	// Another key has its own quota.
+	w = performAs(r, "judy", http.MethodPost, "/limited", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimitRedirectPerClientIP(t *testing.T) {
+	r := newTestRouter(t)
+	limit := store.RateLimit{Rate: 1, Burst: 1}
+	r.GET("/limited/:shortUrl", RateLimit("redirect", &limit), HandleShortUrlRedirect)
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{ShortUrl: "limit1", OriginalUrl: "https://example.com", UserId: testUserId}))
+
+	redirect := func(ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/limited/limit1", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusFound, redirect("192.0.2.1"))
+	assert.Equal(t, http.StatusTooManyRequests, redirect("192.0.2.1"))
+	assert.Equal(t, http.StatusFound, redirect("192.0.2.2"))
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	r := newTestRouter(t)
+	require.NoError(t, r.SetTrustedProxies(nil))
+	limit := store.RateLimit{Rate: 1, Burst: 1}
+	r.POST("/limited/:shortUrl/unlock", RateLimit("unlock", &limit), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	unlock := func(remote string, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodPost, "/limited/limit1/unlock", nil)
+		req.RemoteAddr = remote + ":1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusNoContent, unlock("192.0.2.1", "198.51.100.1"))
+	assert.Equal(t, http.StatusTooManyRequests, unlock("192.0.2.1", "198.51.100.2"), "the header of an untrusted peer is ignored")
+
+	// Behind a trusted proxy the header names the client.
+	require.NoError(t, r.SetTrustedProxies([]string{"10.0.0.0/8"}))
+	assert.Equal(t, http.StatusNoContent, unlock("10.0.0.1", "198.51.100.3"))
+	assert.Equal(t, http.StatusTooManyRequests, unlock("10.0.0.2", "198.51.100.3"))
+	assert.Equal(t, http.StatusNoContent, unlock("10.0.0.1", "198.51.100.4"))
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	r := newTestRouter(t)
+	limit := store.RateLimit{}
+	r.GET("/unlimited", RateLimit("redirect", &limit), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for i := 0; i < 10; i++ {
+		w := perform(r, http.MethodGet, "/unlimited", nil)
+		require.Equal(t, http.StatusNoContent, w.Code)
+	}
+}
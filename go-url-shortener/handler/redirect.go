@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-url-shortener/store"
+)
+
+// RedirectStatuses are the statuses a link may redirect with: 301 and 308 are
+// permanent, 302 and 307 temporary, and 307 and 308 keep the request method
+// and body.
+var RedirectStatuses = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+// PermanentRedirectMaxAge bounds how long clients may cache a permanent
+// redirect. Cached redirects skip the shortener, so their clicks go
+// uncounted and later changes of the target unnoticed until it runs out.
+var PermanentRedirectMaxAge = 24 * time.Hour
+
+var errInvalidRedirectStatus = errors.New("redirect_status must be 301, 302, 307 or 308")
+
+// checkRedirectStatus accepts one of RedirectStatuses, or 0 for
+// store.DefaultRedirectStatus.
+func checkRedirectStatus(status int) error {
+	if status != 0 && !RedirectStatuses[status] {
+		return errInvalidRedirectStatus
+	}
+	return nil
+}
+
+func permanentRedirect(status int) bool {
+	return status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+}
+
+// redirectCacheControl lets clients cache permanent redirects, never past
+// the expiry of the link, and keeps temporary ones from being cached at all.
+// Redirects of password protected links are never stored, lest a shared
+// cache hand the target to visitors who did not unlock them.
+func redirectCacheControl(record *store.LinkRecord, now time.Time) string {
+	if record.PasswordHash != "" {
+		return "private, no-store"
+	}
+	if !permanentRedirect(record.RedirectCode()) {
+		return "private, no-cache"
+	}
+	return fmt.Sprintf("public, max-age=%d", int(cacheMaxAge(record, now, PermanentRedirectMaxAge).Seconds()))
+}
+
+// cacheMaxAge is limit, shortened to the time record has left to live.
+func cacheMaxAge(record *store.LinkRecord, now time.Time, limit time.Duration) time.Duration {
+	if record.ExpiresAt.IsZero() {
+		return limit
+	}
+	left := record.ExpiresAt.Sub(now).Truncate(time.Second)
+	if left < 0 {
+		return 0
+	}
+	if left > limit {
+		return limit
+	}
+	return left
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/store"
+)
+
+func TestRedirectStatuses(t *testing.T) {
+	r := newTestRouter(t)
+	cases := []struct {
+		status       int
+		want         int
+		cacheControl string
+	}{
+		{0, http.StatusFound, "private, no-cache"},
+		{http.StatusMovedPermanently, http.StatusMovedPermanently, "public, max-age=86400"},
+		{http.StatusFound, http.StatusFound, "private, no-cache"},
+		{http.StatusTemporaryRedirect, http.StatusTemporaryRedirect, "private, no-cache"},
+		{http.StatusPermanentRedirect, http.StatusPermanentRedirect, "public, max-age=86400"},
+	}
+	for _, tc := range cases {
+		w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{
+			LongUrl:        "https://example.com/status/" + http.StatusText(tc.want),
+			RedirectStatus: tc.status,
+			ExpiryPolicy:   ExpiryPolicy{NeverExpires: true},
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		created := decodeBody(t, w)
+		assert.Equal(t, float64(tc.want), created["redirect_status"])
+
+		w = perform(r, http.MethodGet, "/"+created["code"].(string), nil)
+		assert.Equal(t, tc.want, w.Code)
+		assert.Equal(t, tc.cacheControl, w.Header().Get("Cache-Control"), "status %d", tc.want)
+	}
+}
+
+func TestRedirectPermanentCacheBoundedByExpiry(t *testing.T) {
+	r := newTestRouter(t)
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{
+		ShortUrl:       "perm1",
+		OriginalUrl:    "https://example.com",
+		UserId:         testUserId,
+		ExpiresAt:      time.Now().Add(10*time.Minute + 30*time.Second),
+		RedirectStatus: http.StatusMovedPermanently,
+	}))
+
+	w := perform(r, http.MethodGet, "/perm1", nil)
+	require.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Contains(t, []string{"public, max-age=629", "public, max-age=630"}, w.Header().Get("Cache-Control"))
+}
+
+func TestRedirectPreservesPost(t *testing.T) {
+	r := newTestRouter(t)
+	r.POST("/:shortUrl", HandleShortUrlRedirect)
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{
+		ShortUrl:       "post1",
+		OriginalUrl:    "https://api.example.com/hook",
+		UserId:         testUserId,
+		RedirectStatus: http.StatusTemporaryRedirect,
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/post1", strings.NewReader(`{"event":"ping"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "https://api.example.com/hook", w.Header().Get("Location"))
+}
+
+func TestCreateShortUrlInvalidRedirectStatus(t *testing.T) {
+	r := newTestRouter(t)
+	for _, status := range []int{200, 303, 304, 404} {
+		w := perform(r, http.MethodPost, "/create-short-url", UrlCreationRequest{LongUrl: "https://example.com", RedirectStatus: status})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, CodeInvalidRedirect, decodeError(t, w).Code)
+	}
+}
+
+func TestRedirectFailsFastWhenStoreIsDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	_, err := store.InitializeStore(store.Options{
+		Backend:          store.BackendRedis,
+		RedisAddr:        mr.Addr(),
+		ReadTimeout:      time.Second,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Minute,
+	})
+	require.NoError(t, err)
+	r := gin.New()
+	r.GET("/:shortUrl", HandleShortUrlRedirect)
+
+	mr.Close()
+	for i := 0; i < 3; i++ {
+		w := perform(r, http.MethodGet, "/abc123", nil)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, CodeServiceUnavailable, decodeError(t, w).Code)
+	}
+	_, err = store.RetrieveLink(context.Background(), "abc123")
+	assert.ErrorIs(t, err, store.ErrCircuitOpen)
+}
+
+// BenchmarkRedirect follows a short url served by Redis, with and without
+// the in-process cache in front of it.
+func BenchmarkRedirect(b *testing.B) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(b)
+	for name, cacheSize := range map[string]int{"uncached": 0, "cached": 1000} {
+		b.Run(name, func(b *testing.B) {
+			s, err := store.InitializeStore(store.Options{
+				Backend:   store.BackendRedis,
+				RedisAddr: mr.Addr(),
+				CacheSize: cacheSize,
+				CacheTTL:  time.Minute,
+			})
+			require.NoError(b, err)
+			defer s.Close()
+			require.NoError(b, store.SaveUrlMapping(context.Background(), &store.LinkRecord{
+				ShortUrl: "bench", OriginalUrl: "https://example.com/bench", UserId: testUserId, CreatedAt: time.Now(),
+			}))
+			r := gin.New()
+			r.GET("/:shortUrl", HandleShortUrlRedirect)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bench", nil))
+				if w.Code != http.StatusFound {
+					b.Fatalf("status %d", w.Code)
+				}
+			}
+		})
+	}
+}
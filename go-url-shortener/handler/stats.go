@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-url-shortener/store"
+)
+
+type StatsBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int64     `json:"clicks"`
+}
+
+type StatsResponse struct {
+	ShortUrl       string             `json:"short_url"`
+	TotalClicks    int64              `json:"total_clicks"`
+	UniqueVisitors int64              `json:"unique_visitors"`
+	Granularity    string             `json:"granularity"`
+	Buckets        []StatsBucket      `json:"buckets"`
+	Countries      map[string]int64   `json:"countries"`
+	Referrers      map[string]int64   `json:"referrers"`
+	RecentClicks   []store.ClickEvent `json:"recent_clicks"`
+}
+
+// LinkStats serves GET /api/links/:code/stats to the owner of the link, the
+// recent clicks telling visitors apart. The granularity query parameter picks
+// "hour" or "day" (the default) buckets.
+func LinkStats(c *gin.Context) {
+	granularity := c.DefaultQuery("granularity", "day")
+	if granularity != "hour" && granularity != "day" {
+		abortWithError(c, http.StatusBadRequest, CodeBadRequest, "granularity must be \"hour\" or \"day\"")
+		return
+	}
+
+	// Expired links keep their statistics for as long as they are retained.
+	record, ok := ownedLink(c)
+	if !ok {
+		return
+	}
+	shortUrl := record.ShortUrl
+	stats, err := store.RetrieveClickStats(c.Request.Context(), shortUrl)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+
+	counts, layout := stats.Daily, store.DayLayout
+	if granularity == "hour" {
+		counts, layout = stats.Hourly, store.HourLayout
+	}
+	c.JSON(http.StatusOK, StatsResponse{
+		ShortUrl:       shortUrl,
+		TotalClicks:    stats.Total,
+		UniqueVisitors: stats.UniqueVisitors,
+		Granularity:    granularity,
+		Buckets:        buckets(counts, layout),
+		Countries:      stats.Countries,
+		Referrers:      stats.Referrers,
+		RecentClicks:   stats.Recent,
+	})
+}
+
+// buckets turns the keyed counts into a chronological list.
+func buckets(counts map[string]int64, layout string) []StatsBucket {
+	result := make([]StatsBucket, 0, len(counts))
+	for key, clicks := range counts {
+		start, err := time.Parse(layout, key)
+		if err != nil {
+			continue
+		}
+		result = append(result, StatsBucket{Start: start, Clicks: clicks})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Start.Before(result[j].Start)
+	})
+	return result
+}
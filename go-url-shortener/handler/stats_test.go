@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-url-shortener/analytics"
+	"go-url-shortener/store"
+)
+
+func TestLinkStats(t *testing.T) {
+	r := newTestRouter(t)
+	s, err := store.InitializeStore(store.Options{Backend: store.BackendMemory})
+	require.NoError(t, err)
+	recorder, err := analytics.InitializeRecorder(s, analytics.Options{IPHashSalt: "test"})
+	require.NoError(t, err)
+
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{ShortUrl: "stats1", OriginalUrl: "https://example.com/stats", UserId: testUserId}))
+	for i := 0; i < 3; i++ {
+		w := perform(r, http.MethodGet, "/stats1", nil)
+		require.Equal(t, http.StatusFound, w.Code)
+	}
+	// Closing drains the pipeline so every click is in the store.
+	require.NoError(t, recorder.Close())
+
+	w := perform(r, http.MethodGet, "/api/links/stats1/stats?granularity=hour", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp StatsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(3), resp.TotalClicks)
+	assert.Equal(t, int64(1), resp.UniqueVisitors)
+	assert.Equal(t, "hour", resp.Granularity)
+	require.Len(t, resp.Buckets, 1)
+	assert.Equal(t, int64(3), resp.Buckets[0].Clicks)
+	assert.Equal(t, time.Now().UTC().Truncate(time.Hour), resp.Buckets[0].Start)
+}
+
+func TestLinkStatsErrors(t *testing.T) {
+	r := newTestRouter(t)
+	require.NoError(t, store.CreateUrlMapping(context.Background(), &store.LinkRecord{ShortUrl: "stats2", OriginalUrl: "https://example.com/stats", UserId: testUserId}))
+
+	w := perform(r, http.MethodGet, "/api/links/unknown/stats", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = perform(r, http.MethodGet, "/api/links/stats2/stats?granularity=week", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performAs(r, "mallory", http.MethodGet, "/api/links/stats2/stats", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, CodeForbidden, decodeError(t, w).Code)
+	w = performAs(r, "", http.MethodGet, "/api/links/stats2/stats", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
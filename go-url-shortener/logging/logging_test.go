@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDIsLogged(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(&out, DefaultOptions())
+	require.NoError(t, err)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	assert.Equal(t, "req-1", RequestID(ctx))
+	logger.With("component", "store").InfoContext(ctx, "saved", "code", "abc")
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "saved", line["msg"])
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Equal(t, "store", line["component"])
+	assert.Equal(t, "abc", line["code"])
+
+	out.Reset()
+	logger.Info("no request")
+	require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.NotContains(t, out.String(), "request_id")
+}
+
+func TestOptions(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(&out, Options{Level: "warn", Format: FormatText})
+	require.NoError(t, err)
+	logger.Info("hidden")
+	logger.Warn("shown")
+	assert.NotContains(t, out.String(), "hidden")
+	assert.Contains(t, out.String(), "msg=shown")
+
+	level, err := ParseLevel("DEBUG")
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelDebug, level)
+
+	_, err = New(&out, Options{Level: "loud", Format: FormatJSON})
+	assert.Error(t, err)
+	_, err = New(&out, Options{Level: "info", Format: "xml"})
+	assert.Error(t, err)
+}
@@ -1,33 +1,142 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
+	"go-url-shortener/analytics"
+	"go-url-shortener/config"
 	"go-url-shortener/handler"
+	"go-url-shortener/logging"
 	"go-url-shortener/store"
 )
 
 func main() {
-	r := gin.Default()
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration - Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := cfg.Apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration - Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Log); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration - Error: %v\n", err)
+		os.Exit(2)
+	}
+	// The access log replaces the one gin writes in debug mode.
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	r := gin.New()
+	// Client IPs key the rate limits, so X-Forwarded-For is only believed
+	// from the configured proxies.
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration - Error: %v\n", err)
+		os.Exit(2)
+	}
+	r.Use(gin.Recovery(), handler.RequestID, handler.AccessLog, handler.Metrics)
 	r.GET("/", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message": "Hey Go URL Shortener !",
 		})
 	})
 
-	r.POST("/create-short-url", func(c *gin.Context) {
+	r.GET("/healthz", handler.Healthz)
+	r.GET("/readyz", handler.Readyz)
+	r.GET("/metrics", handler.MetricsEndpoint())
+
+	authorized := r.Group("/", handler.RequireApiKey)
+
+	authorized.POST("/create-short-url", handler.RateLimit("create", &handler.CreateRateLimit), func(c *gin.Context) {
 		handler.CreateShortUrl(c)
 	})
 
-	r.GET("/:shortUrl", func(c *gin.Context) {
+	// Bulk creation charges its own quota row by row.
+	authorized.POST("/api/links/bulk", func(c *gin.Context) {
+		handler.CreateLinksBulk(c)
+	})
+
+	r.GET("/:shortUrl", handler.RateLimit("redirect", &handler.RedirectRateLimit), func(c *gin.Context) {
+		handler.HandleShortUrlRedirect(c)
+	})
+
+	// Links redirecting with 307 or 308 forward POST requests, body included.
+	r.POST("/:shortUrl", handler.RateLimit("redirect", &handler.RedirectRateLimit), func(c *gin.Context) {
 		handler.HandleShortUrlRedirect(c)
 	})
 
-	store.InitializeStore()
+	r.POST("/:shortUrl/unlock", handler.RateLimit("unlock", &handler.UnlockRateLimit), func(c *gin.Context) {
+		handler.UnlockLink(c)
+	})
 
-	err := r.Run(":9808")
+	r.GET("/:shortUrl/qr", func(c *gin.Context) {
+		handler.LinkQRCode(c)
+	})
+
+	authorized.GET("/api/links/:code/stats", func(c *gin.Context) {
+		handler.LinkStats(c)
+	})
+
+	authorized.GET("/api/users/:id/links", func(c *gin.Context) {
+		handler.ListUserLinks(c)
+	})
+
+	authorized.PATCH("/api/links/:code", func(c *gin.Context) {
+		handler.UpdateLink(c)
+	})
+
+	authorized.DELETE("/api/links/:code", func(c *gin.Context) {
+		handler.DeleteLink(c)
+	})
+
+	s, err := store.InitializeStore(cfg.Store)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize the store - Error: %v", err))
+	}
+	defer s.Close()
+
+	recorder, err := analytics.InitializeRecorder(s, cfg.Analytics)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to start the web server - Error: %v", err))
+		panic(fmt.Sprintf("Failed to start click analytics - Error: %v", err))
+	}
+	defer recorder.Close()
+
+	// The deferred closes run once the server has drained, flushing the clicks
+	// still queued before the store goes away.
+	server := &http.Server{Addr: cfg.ListenAddr, Handler: r}
+	stopped, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-served:
+		if !errors.Is(err, http.ErrServerClosed) {
+			panic(fmt.Sprintf("Failed to start the web server - Error: %v", err))
+		}
+		return
+	case <-stopped.Done():
+	}
+	stop()
+
+	slog.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	handler.BeginShutdown()
+	shutdown, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil {
+		slog.Error("requests still in flight were dropped", "error", err)
 	}
 }
@@ -0,0 +1,136 @@
+// Package qr renders QR codes of short urls as PNG or SVG images.
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	// DefaultMargin is the quiet zone the QR specification asks for, in
+	// modules.
+	DefaultMargin = 4
+	MaxMargin     = 16
+)
+
+var (
+	ErrLevel       = errors.New("error correction level must be one of L, M, Q or H")
+	ErrTooSmall    = errors.New("image too small for the code")
+	ErrMargin      = fmt.Errorf("margin must be between 0 and %d modules", MaxMargin)
+	recoveryLevels = map[string]qrcode.RecoveryLevel{
+		"L": qrcode.Low,
+		"M": qrcode.Medium,
+		"Q": qrcode.High,
+		"H": qrcode.Highest,
+	}
+)
+
+// Code is the module matrix of a QR code surrounded by its margin.
+type Code struct {
+	modules [][]bool
+}
+
+// Encode builds the QR code of content with the error correction level L, M,
+// Q or H and margin modules of quiet zone around it.
+func Encode(content string, level string, margin int) (*Code, error) {
+	recovery, ok := recoveryLevels[strings.ToUpper(level)]
+	if !ok {
+		return nil, ErrLevel
+	}
+	if margin < 0 || margin > MaxMargin {
+		return nil, ErrMargin
+	}
+	q, err := qrcode.New(content, recovery)
+	if err != nil {
+		return nil, err
+	}
+	q.DisableBorder = true
+	symbol := q.Bitmap()
+
+	size := len(symbol) + 2*margin
+	modules := make([][]bool, size)
+	for y := range modules {
+		modules[y] = make([]bool, size)
+		if y >= margin && y < margin+len(symbol) {
+			copy(modules[y][margin:], symbol[y-margin])
+		}
+	}
+	return &Code{modules: modules}, nil
+}
+
+// Modules is the width and height of the code, margin included.
+func (code *Code) Modules() int {
+	return len(code.modules)
+}
+
+// layout fits the code in size pixels: every module is scale pixels wide and
+// the pixels left over are split around it.
+func (code *Code) layout(size int) (scale int, offset int, err error) {
+	scale = size / code.Modules()
+	if scale < 1 {
+		return 0, 0, fmt.Errorf("%w: needs at least %d pixels", ErrTooSmall, code.Modules())
+	}
+	return scale, (size - scale*code.Modules()) / 2, nil
+}
+
+// PNG draws the code as a size x size black and white image.
+func (code *Code) PNG(size int) ([]byte, error) {
+	scale, offset, err := code.layout(size)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y, row := range code.modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				start := img.PixOffset(offset+x*scale, offset+y*scale+py)
+				for px := 0; px < scale; px++ {
+					img.Pix[start+px] = 1
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG draws the code as a size x size pixels vector image, one path run per
+// horizontal stretch of dark modules.
+func (code *Code) SVG(size int) ([]byte, error) {
+	if _, _, err := code.layout(size); err != nil {
+		return nil, err
+	}
+	n := code.Modules()
+	var path strings.Builder
+	for y, row := range code.modules {
+		for x := 0; x < n; x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < n && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, n, n)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="%s"/></svg>`+"\n", n, n, path.String())
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeMargin(t *testing.T) {
+	bare, err := Encode("http://localhost:9808/jTa4L57P", "M", 0)
+	require.NoError(t, err)
+	padded, err := Encode("http://localhost:9808/jTa4L57P", "M", DefaultMargin)
+	require.NoError(t, err)
+	assert.Equal(t, bare.Modules()+2*DefaultMargin, padded.Modules())
+
+	// The finder pattern starts right after the margin.
+	assert.True(t, bare.modules[0][0])
+	assert.False(t, padded.modules[DefaultMargin-1][DefaultMargin-1])
+	assert.True(t, padded.modules[DefaultMargin][DefaultMargin])
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	_, err := Encode("x", "Z", DefaultMargin)
+	assert.ErrorIs(t, err, ErrLevel)
+	_, err = Encode("x", "H", MaxMargin+1)
+	assert.ErrorIs(t, err, ErrMargin)
+	_, err = Encode("x", "H", -1)
+	assert.ErrorIs(t, err, ErrMargin)
+}
+
+func TestHigherLevelNeedsMoreModules(t *testing.T) {
+	low, err := Encode("http://localhost:9808/jTa4L57P-with-a-longer-path", "l", 0)
+	require.NoError(t, err)
+	high, err := Encode("http://localhost:9808/jTa4L57P-with-a-longer-path", "H", 0)
+	require.NoError(t, err)
+	assert.Greater(t, high.Modules(), low.Modules())
+}
+
+func TestPNG(t *testing.T) {
+	code, err := Encode("http://localhost:9808/jTa4L57P", "M", DefaultMargin)
+	require.NoError(t, err)
+	data, err := code.PNG(300)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 300, img.Bounds().Dx())
+	assert.Equal(t, 300, img.Bounds().Dy())
+
+	scale := 300 / code.Modules()
+	offset := (300 - scale*code.Modules()) / 2
+	dark := func(x, y int) bool {
+		r, _, _, _ := img.At(offset+x*scale+scale/2, offset+y*scale+scale/2).RGBA()
+		return r == 0
+	}
+	for y := 0; y < code.Modules(); y++ {
+		for x := 0; x < code.Modules(); x++ {
+			require.Equal(t, code.modules[y][x], dark(x, y), "module %d,%d", x, y)
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	code, err := Encode("http://localhost:9808/jTa4L57P", "Q", 2)
+	require.NoError(t, err)
+	data, err := code.SVG(512)
+	require.NoError(t, err)
+
+	svg := string(data)
+	assert.True(t, strings.HasPrefix(svg, "<?xml"))
+	assert.Contains(t, svg, `width="512" height="512"`)
+	assert.Contains(t, svg, fmt.Sprintf(`viewBox="0 0 %d %d"`, code.Modules(), code.Modules()))
+	// The top left finder pattern is a run of seven dark modules.
+	assert.Contains(t, svg, "M2 2h7v1h-7z")
+}
+
+func TestTooSmall(t *testing.T) {
+	code, err := Encode("http://localhost:9808/jTa4L57P", "M", DefaultMargin)
+	require.NoError(t, err)
+	_, err = code.PNG(code.Modules() - 1)
+	assert.ErrorIs(t, err, ErrTooSmall)
+	_, err = code.SVG(code.Modules() - 1)
+	assert.ErrorIs(t, err, ErrTooSmall)
+}
@@ -0,0 +1,54 @@
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	MinAliasLength = 3
+	MaxAliasLength = 64
+)
+
+var (
+	ErrAliasInvalid  = errors.New("invalid custom alias")
+	ErrAliasReserved = errors.New("custom alias is reserved")
+
+	aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+	// ReservedAliases are path segments owned by the server's own routes.
+	ReservedAliases = map[string]bool{
+		"create-short-url": true,
+		"api":              true,
+		"admin":            true,
+		"health":           true,
+		"healthz":          true,
+		"readyz":           true,
+		"metrics":          true,
+		"static":           true,
+	}
+)
+
+// IsCode reports whether code could name a link: a generated code or an
+// alias, made of the characters both are drawn from.
+func IsCode(code string) bool {
+	return len(code) <= MaxCodeLength && aliasPattern.MatchString(code)
+}
+
+// ValidateAlias checks a user supplied vanity alias: letters, digits, '-' and
+// '_' only, MinAliasLength to MaxAliasLength long and not one of
+// ReservedAliases (compared case-insensitively).
+func ValidateAlias(alias string) error {
+	if len(alias) < MinAliasLength || len(alias) > MaxAliasLength {
+		return fmt.Errorf("%w: must be between %d and %d characters", ErrAliasInvalid, MinAliasLength, MaxAliasLength)
+	}
+	if !aliasPattern.MatchString(alias) {
+		return fmt.Errorf("%w: only letters, digits, '-' and '_' are allowed", ErrAliasInvalid)
+	}
+	if ReservedAliases[strings.ToLower(alias)] {
+		return fmt.Errorf("%w: %q", ErrAliasReserved, alias)
+	}
+	return nil
+}
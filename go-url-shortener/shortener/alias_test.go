@@ -0,0 +1,32 @@
+package shortener
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAlias(t *testing.T) {
+	assert.NoError(t, ValidateAlias("spring-sale"))
+	assert.NoError(t, ValidateAlias("Promo_2024"))
+
+	assert.ErrorIs(t, ValidateAlias("ab"), ErrAliasInvalid)
+	assert.ErrorIs(t, ValidateAlias(strings.Repeat("a", MaxAliasLength+1)), ErrAliasInvalid)
+	assert.ErrorIs(t, ValidateAlias("spring sale"), ErrAliasInvalid)
+	assert.ErrorIs(t, ValidateAlias("sale/2024"), ErrAliasInvalid)
+	assert.ErrorIs(t, ValidateAlias("café"), ErrAliasInvalid)
+
+	assert.ErrorIs(t, ValidateAlias("create-short-url"), ErrAliasReserved)
+	assert.ErrorIs(t, ValidateAlias("API"), ErrAliasReserved)
+}
+
+func TestIsCode(t *testing.T) {
+	assert.True(t, IsCode("jTa4L57P"))
+	assert.True(t, IsCode("spring-sale"))
+
+	assert.False(t, IsCode(""))
+	assert.False(t, IsCode("seq:short_codes"))
+	assert.False(t, IsCode("_sys:apikey:k1"))
+	assert.False(t, IsCode(strings.Repeat("a", MaxCodeLength+1)))
+}
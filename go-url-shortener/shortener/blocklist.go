@@ -0,0 +1,68 @@
+package shortener
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// FileBlocklist is a Blocklist read from a text file with one rule per line.
+// A plain domain blocks itself and its subdomains, "re:<expression>" blocks
+// the normalized urls matching the regular expression. Blank lines and lines
+// starting with '#' are ignored.
+type FileBlocklist struct {
+	domains  map[string]bool
+	patterns []*regexp.Regexp
+}
+
+func LoadBlocklist(path string) (*FileBlocklist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	blocklist := &FileBlocklist{domains: map[string]bool{}}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		rule := strings.TrimSpace(scanner.Text())
+		switch {
+		case rule == "" || strings.HasPrefix(rule, "#"):
+		case strings.HasPrefix(rule, "re:"):
+			pattern, err := regexp.Compile(strings.TrimPrefix(rule, "re:"))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			blocklist.patterns = append(blocklist.patterns, pattern)
+		default:
+			blocklist.domains[strings.TrimSuffix(strings.ToLower(rule), ".")] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return blocklist, nil
+}
+
+func (b *FileBlocklist) Blocked(target *url.URL) (string, bool) {
+	host := target.Hostname()
+	for domain := host; domain != ""; {
+		if b.domains[domain] {
+			return domain, true
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+	for _, pattern := range b.patterns {
+		if pattern.MatchString(target.String()) {
+			return "re:" + pattern.String(), true
+		}
+	}
+	return "", false
+}
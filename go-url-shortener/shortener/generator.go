@@ -0,0 +1,202 @@
+package shortener
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Base58Alphabet is the bitcoin alphabet the hash scheme always used.
+	Base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	Base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	MaxCodeLength = MaxAliasLength
+)
+
+const (
+	StrategyHash    = "hash"
+	StrategyCounter = "counter"
+	StrategyRandom  = "random"
+)
+
+// CodeGenerator produces the candidate short codes GenerateUniqueCode tries
+// for a url, attempt counting the collisions so far. ctx is the one of the
+// request the code is for.
+type CodeGenerator interface {
+	Generate(ctx context.Context, initialLink string, userId string, attempt int) (string, error)
+}
+
+var ErrInvalidAlphabet = errors.New("invalid code alphabet")
+
+// ValidateAlphabet accepts at least two distinct characters that are valid
+// in an alias, so generated codes never need escaping.
+func ValidateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return fmt.Errorf("%w: needs at least 2 characters", ErrInvalidAlphabet)
+	}
+	if !aliasPattern.MatchString(alphabet) {
+		return fmt.Errorf("%w: only letters, digits, '-' and '_' are allowed", ErrInvalidAlphabet)
+	}
+	for i := range alphabet {
+		if strings.IndexByte(alphabet, alphabet[i]) != i {
+			return fmt.Errorf("%w: %q repeats", ErrInvalidAlphabet, alphabet[i])
+		}
+	}
+	return nil
+}
+
+// encode writes n in the positional system of alphabet, padding it on the
+// left with the zero digit up to minLength.
+func encode(n uint64, alphabet string, minLength int) string {
+	base := uint64(len(alphabet))
+	var digits []byte
+	for n > 0 || len(digits) == 0 {
+		digits = append(digits, alphabet[n%base])
+		n /= base
+	}
+	for len(digits) < minLength {
+		digits = append(digits, alphabet[0])
+	}
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits)
+}
+
+// HashGenerator is the original scheme: the last 64 bits of the sha256 of
+// url and user, written in Alphabet and cut to Length characters. Retries
+// salt the hash with the attempt number and every third one grows the code
+// by a character, up to the full encoded number. Codes only depend on their
+// input, with the default settings they match every code handed out so far.
+type HashGenerator struct {
+	Alphabet string
+	Length   int
+}
+
+func DefaultHashGenerator() HashGenerator {
+	return HashGenerator{Alphabet: Base58Alphabet, Length: ShortLinkLength}
+}
+
+func (g HashGenerator) Generate(_ context.Context, initialLink string, userId string, attempt int) (string, error) {
+	input := initialLink + userId
+	if attempt > 0 {
+		input += "#" + strconv.Itoa(attempt)
+	}
+	hash := sha256Of(input)
+	var number uint64
+	for _, b := range hash[len(hash)-8:] {
+		number = number<<8 | uint64(b)
+	}
+	encoded := encode(number, g.Alphabet, 0)
+
+	length := g.Length + attempt/3
+	if length > len(encoded) {
+		length = len(encoded)
+	}
+	return encoded[:length], nil
+}
+
+// CounterGenerator writes the values of a shared sequence in Alphabet, padded
+// to at least Length characters. Every value is used once, so only custom
+// aliases can collide with it, but consecutive codes are guessable.
+type CounterGenerator struct {
+	Alphabet string
+	Length   int
+	// Next returns the next value of the sequence, e.g. an atomic counter of
+	// the store shared by every instance.
+	Next func(ctx context.Context) (uint64, error)
+}
+
+func (g CounterGenerator) Generate(ctx context.Context, _ string, _ string, _ int) (string, error) {
+	value, err := g.Next(ctx)
+	if err != nil {
+		return "", err
+	}
+	return encode(value, g.Alphabet, g.Length), nil
+}
+
+// RandomGenerator draws Length characters of Alphabet from crypto/rand,
+// growing the code by a character every third retry like HashGenerator.
+type RandomGenerator struct {
+	Alphabet string
+	Length   int
+}
+
+func (g RandomGenerator) Generate(_ context.Context, _ string, _ string, attempt int) (string, error) {
+	length := g.Length + attempt/3
+	if length > MaxCodeLength {
+		length = MaxCodeLength
+	}
+	// Bytes at or above limit are rejected so every character stays equally
+	// likely.
+	limit := 256 - 256%len(g.Alphabet)
+	code := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(code) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(code) < length {
+				code = append(code, g.Alphabet[int(b)%len(g.Alphabet)])
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// NewCodeGenerator builds the generator of strategy. An empty alphabet picks
+// base58 for the hash scheme and base62 otherwise; next is only used by the
+// counter strategy.
+func NewCodeGenerator(strategy string, alphabet string, length int, next func(ctx context.Context) (uint64, error)) (CodeGenerator, error) {
+	if alphabet == "" {
+		alphabet = Base62Alphabet
+		if strategy == StrategyHash {
+			alphabet = Base58Alphabet
+		}
+	}
+	if err := ValidateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	if length < 1 || length > MaxCodeLength {
+		return nil, fmt.Errorf("code length must be between 1 and %d", MaxCodeLength)
+	}
+
+	switch strategy {
+	case StrategyHash:
+		return HashGenerator{Alphabet: alphabet, Length: length}, nil
+	case StrategyCounter:
+		if next == nil {
+			return nil, errors.New("counter strategy needs a sequence")
+		}
+		return CounterGenerator{Alphabet: alphabet, Length: length, Next: next}, nil
+	case StrategyRandom:
+		return RandomGenerator{Alphabet: alphabet, Length: length}, nil
+	default:
+		return nil, fmt.Errorf("unknown code strategy %q", strategy)
+	}
+}
+
+// GenerateUniqueCode hands the candidates of generator to claim, which must
+// atomically reserve one and report false when it is already taken. The
+// first claimed candidate is returned.
+func GenerateUniqueCode(ctx context.Context, generator CodeGenerator, initialLink string, userId string, claim func(shortUrl string) (bool, error)) (string, error) {
+	for attempt := 0; attempt < MaxAttempts; attempt++ {
+		shortUrl, err := generator.Generate(ctx, initialLink, userId, attempt)
+		if err != nil {
+			return "", err
+		}
+		claimed, err := claim(shortUrl)
+		if err != nil {
+			return "", err
+		}
+		if claimed {
+			return shortUrl, nil
+		}
+	}
+	return "", ErrNoFreeShortLink
+}
@@ -0,0 +1,236 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sequence() func(context.Context) (uint64, error) {
+	var counter uint64
+	return func(context.Context) (uint64, error) {
+		return atomic.AddUint64(&counter, 1), nil
+	}
+}
+
+func TestEncode(t *testing.T) {
+	assert.Equal(t, "0", encode(0, Base62Alphabet, 0))
+	assert.Equal(t, "z", encode(61, Base62Alphabet, 0))
+	assert.Equal(t, "10", encode(62, Base62Alphabet, 0))
+	assert.Equal(t, "00010", encode(62, Base62Alphabet, 5))
+	assert.Equal(t, "101", encode(5, "01", 0))
+}
+
+func TestValidateAlphabet(t *testing.T) {
+	assert.NoError(t, ValidateAlphabet(Base58Alphabet))
+	assert.NoError(t, ValidateAlphabet(Base62Alphabet))
+	assert.ErrorIs(t, ValidateAlphabet("a"), ErrInvalidAlphabet)
+	assert.ErrorIs(t, ValidateAlphabet("abca"), ErrInvalidAlphabet)
+	assert.ErrorIs(t, ValidateAlphabet("ab/"), ErrInvalidAlphabet)
+}
+
+func TestHashGeneratorAlphabet(t *testing.T) {
+	generator := HashGenerator{Alphabet: "0123456789abcdef", Length: 10}
+	code, err := generator.Generate(context.Background(), "https://example.com", UserId, 0)
+	require.NoError(t, err)
+	assert.Len(t, code, 10)
+	assert.Empty(t, strings.Trim(code, generator.Alphabet))
+
+	again, _ := generator.Generate(context.Background(), "https://example.com", UserId, 0)
+	assert.Equal(t, code, again)
+}
+
+func TestCounterGenerator(t *testing.T) {
+	generator, err := NewCodeGenerator(StrategyCounter, "", 4, sequence())
+	require.NoError(t, err)
+
+	var codes []string
+	for i := 0; i < 3; i++ {
+		code, err := generator.Generate(context.Background(), "https://example.com", UserId, 0)
+		require.NoError(t, err)
+		codes = append(codes, code)
+	}
+	assert.Equal(t, []string{"0001", "0002", "0003"}, codes)
+}
+
+func TestRandomGenerator(t *testing.T) {
+	generator, err := NewCodeGenerator(StrategyRandom, "abc", 12, nil)
+	require.NoError(t, err)
+
+	code, err := generator.Generate(context.Background(), "https://example.com", UserId, 0)
+	require.NoError(t, err)
+	assert.Len(t, code, 12)
+	assert.Empty(t, strings.Trim(code, "abc"))
+
+	grown, err := generator.Generate(context.Background(), "https://example.com", UserId, 6)
+	require.NoError(t, err)
+	assert.Len(t, grown, 14)
+}
+
+func TestNewCodeGenerator(t *testing.T) {
+	generator, err := NewCodeGenerator(StrategyHash, "", ShortLinkLength, nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultHashGenerator(), generator)
+
+	for _, bad := range []struct {
+		strategy string
+		alphabet string
+		length   int
+	}{
+		{"sequential", "", 8},
+		{StrategyCounter, "", 8},
+		{StrategyRandom, "a", 8},
+		{StrategyRandom, "", 0},
+		{StrategyRandom, "", MaxCodeLength + 1},
+	} {
+		_, err := NewCodeGenerator(bad.strategy, bad.alphabet, bad.length, nil)
+		assert.Error(t, err, "%+v", bad)
+	}
+}
+
+func TestGenerateUniqueCodeGeneratorError(t *testing.T) {
+	boom := fmt.Errorf("counter down")
+	generator := CounterGenerator{Alphabet: Base62Alphabet, Next: func(context.Context) (uint64, error) { return 0, boom }}
+	_, err := GenerateUniqueCode(context.Background(), generator, "https://example.com", UserId, func(string) (bool, error) {
+		return true, nil
+	})
+	assert.ErrorIs(t, err, boom)
+}
+
+func TestGenerateUniqueCodeResolvesCollisions(t *testing.T) {
+	initialLink := "https://spectrum.ieee.org/automaton/robotics/home-robots/hello-robots-stretch-mobile-manipulator"
+	generator := DefaultHashGenerator()
+
+	// Pretend the first three candidates belong to other urls.
+	taken := map[string]bool{}
+	for attempt := 0; attempt < 3; attempt++ {
+		candidate, err := generator.Generate(context.Background(), initialLink, UserId, attempt)
+		require.NoError(t, err)
+		taken[candidate] = true
+	}
+
+	var tried []string
+	shortLink, err := GenerateUniqueCode(context.Background(), generator, initialLink, UserId, func(shortUrl string) (bool, error) {
+		tried = append(tried, shortUrl)
+		if taken[shortUrl] {
+			return false, nil
+		}
+		taken[shortUrl] = true
+		return true, nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, tried, 4)
+	assert.Equal(t, tried[3], shortLink)
+}
+
+func TestGenerateUniqueCodeExhausted(t *testing.T) {
+	calls := 0
+	_, err := GenerateUniqueCode(context.Background(), DefaultHashGenerator(), "https://example.com", UserId, func(string) (bool, error) {
+		calls++
+		return false, nil
+	})
+	assert.ErrorIs(t, err, ErrNoFreeShortLink)
+	assert.Equal(t, MaxAttempts, calls)
+}
+
+func TestGenerateUniqueCodeClaimError(t *testing.T) {
+	boom := fmt.Errorf("backend down")
+	_, err := GenerateUniqueCode(context.Background(), DefaultHashGenerator(), "https://example.com", UserId, func(string) (bool, error) {
+		return false, boom
+	})
+	assert.ErrorIs(t, err, boom)
+}
+
+// chiSquare measures how far the character frequencies of codes are from
+// uniform over alphabet; about len(alphabet)-1 is expected for a uniform
+// source.
+func chiSquare(codes []string, alphabet string) float64 {
+	counts := map[rune]int{}
+	total := 0
+	for _, code := range codes {
+		for _, r := range code {
+			counts[r]++
+			total++
+		}
+	}
+	expected := float64(total) / float64(len(alphabet))
+	chi := 0.0
+	for _, r := range alphabet {
+		diff := float64(counts[r]) - expected
+		chi += diff * diff / expected
+	}
+	return chi
+}
+
+func testGenerators() map[string]CodeGenerator {
+	return map[string]CodeGenerator{
+		StrategyHash:    DefaultHashGenerator(),
+		StrategyCounter: CounterGenerator{Alphabet: Base62Alphabet, Length: ShortLinkLength, Next: sequence()},
+		StrategyRandom:  RandomGenerator{Alphabet: Base62Alphabet, Length: ShortLinkLength},
+	}
+}
+
+func TestGeneratorDistribution(t *testing.T) {
+	hashCodes := make([]string, 20000)
+	randomCodes := make([]string, 20000)
+	for i := range hashCodes {
+		code, _ := HashGenerator{Alphabet: Base58Alphabet, Length: 6}.Generate(context.Background(), "https://example.com/"+strconv.Itoa(i), UserId, 0)
+		// The leading digit of a 64 bit number written in base 58 is skewed,
+		// the rest are uniform.
+		hashCodes[i] = code[1:]
+		randomCodes[i], _ = RandomGenerator{Alphabet: Base62Alphabet, Length: 6}.Generate(context.Background(), "", "", 0)
+	}
+	// Far above the 99.9th percentile for ~60 degrees of freedom, so only a
+	// badly skewed generator fails.
+	assert.Less(t, chiSquare(hashCodes, Base58Alphabet), 150.0)
+	assert.Less(t, chiSquare(randomCodes, Base62Alphabet), 150.0)
+}
+
+// BenchmarkGenerators measures the cost of a candidate.
+func BenchmarkGenerators(b *testing.B) {
+	for name, generator := range testGenerators() {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := generator.Generate(context.Background(), "https://example.com/"+strconv.Itoa(i), UserId, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGeneratorCollisions generates b.N four character codes and reports
+// the share of them that collided with an earlier one and the chi-square of
+// their characters against a uniform distribution.
+func BenchmarkGeneratorCollisions(b *testing.B) {
+	for name, generator := range map[string]CodeGenerator{
+		StrategyHash:    HashGenerator{Alphabet: Base62Alphabet, Length: 4},
+		StrategyCounter: CounterGenerator{Alphabet: Base62Alphabet, Length: 4, Next: sequence()},
+		StrategyRandom:  RandomGenerator{Alphabet: Base62Alphabet, Length: 4},
+	} {
+		b.Run(name, func(b *testing.B) {
+			seen := make(map[string]bool, b.N)
+			codes := make([]string, 0, b.N)
+			collisions := 0
+			for i := 0; i < b.N; i++ {
+				code, err := generator.Generate(context.Background(), "https://example.com/"+strconv.Itoa(i), UserId, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if seen[code] {
+					collisions++
+				}
+				seen[code] = true
+				codes = append(codes, code)
+			}
+			b.ReportMetric(float64(collisions)/float64(b.N), "collisions/op")
+			b.ReportMetric(chiSquare(codes, Base62Alphabet), "chi2")
+		})
+	}
+}
@@ -1,12 +1,9 @@
 package shortener
 
 import (
+	"context"
 	"crypto/sha256"
-	"fmt"
-	"math/big"
-	"os"
-
-	"github.com/itchyny/base58-go"
+	"errors"
 )
 
 func sha256Of(input string) []byte {
@@ -15,19 +12,16 @@ func sha256Of(input string) []byte {
 	return algorithm.Sum(nil)
 }
 
-func base58Encoded(bytes []byte) string {
-	encoding := base58.BitcoinEncoding
-	encoded, err := encoding.Encode(bytes)
-	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
-	}
-	return string(encoded)
-}
+const (
+	ShortLinkLength = 8
+	// MaxAttempts bounds how many candidates GenerateUniqueCode hands to
+	// claim before giving up with ErrNoFreeShortLink.
+	MaxAttempts = 10
+)
+
+var ErrNoFreeShortLink = errors.New("no free short link found")
 
 func GenerateShortLink(initialLink string, userId string) string {
-	urlHashBytes := sha256Of(initialLink + userId)
-	generatedNumber := new(big.Int).SetBytes(urlHashBytes).Uint64()
-	finalString := base58Encoded([]byte(fmt.Sprintf("%d", generatedNumber)))
-	return finalString[:8]
+	shortLink, _ := DefaultHashGenerator().Generate(context.Background(), initialLink, userId, 0)
+	return shortLink
 }
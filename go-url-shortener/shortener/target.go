@@ -0,0 +1,115 @@
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const DefaultMaxTargetLength = 2048
+
+var (
+	ErrTargetInvalid       = errors.New("invalid target url")
+	ErrTargetSelfReference = errors.New("target url points back at the shortener")
+	ErrTargetBlocked       = errors.New("target url is blocked")
+)
+
+// Blocklist decides which normalized target urls may not be shortened,
+// returning the rule that matched.
+type Blocklist interface {
+	Blocked(target *url.URL) (string, bool)
+}
+
+// TargetPolicy is what NormalizeTarget checks a target url against.
+type TargetPolicy struct {
+	// Schemes is the allowlist of url schemes, compared lowercased.
+	Schemes   []string
+	MaxLength int
+	// SelfHosts are the host[:port] the shortener is served from; targets on
+	// them would redirect in a loop.
+	SelfHosts []string
+	Blocklist Blocklist
+}
+
+func DefaultTargetPolicy() TargetPolicy {
+	return TargetPolicy{
+		Schemes:   []string{"http", "https"},
+		MaxLength: DefaultMaxTargetLength,
+	}
+}
+
+// normalizeHost lowercases host, drops the trailing dot of a fully qualified
+// name and the port when it is the default one of scheme.
+func normalizeHost(scheme string, host string) string {
+	host = strings.ToLower(host)
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), ""
+	}
+	name = strings.TrimSuffix(name, ".")
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port == "" {
+		if strings.Contains(name, ":") {
+			return "[" + name + "]"
+		}
+		return name
+	}
+	return net.JoinHostPort(name, port)
+}
+
+// NormalizeTarget validates a user supplied target url and returns its
+// normalized form: lowercased scheme and host, no default port and at least
+// "/" as path.
+func (policy TargetPolicy) NormalizeTarget(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if policy.MaxLength > 0 && len(raw) > policy.MaxLength {
+		return "", fmt.Errorf("%w: longer than %d characters", ErrTargetInvalid, policy.MaxLength)
+	}
+	target, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTargetInvalid, err)
+	}
+
+	target.Scheme = strings.ToLower(target.Scheme)
+	if target.Scheme == "" {
+		return "", fmt.Errorf("%w: must be an absolute url with a scheme", ErrTargetInvalid)
+	}
+	allowed := false
+	for _, scheme := range policy.Schemes {
+		allowed = allowed || strings.ToLower(scheme) == target.Scheme
+	}
+	if !allowed {
+		return "", fmt.Errorf("%w: scheme %q is not allowed, use one of %s", ErrTargetInvalid, target.Scheme, strings.Join(policy.Schemes, ", "))
+	}
+	if target.Opaque != "" || target.Hostname() == "" {
+		return "", fmt.Errorf("%w: a host is required", ErrTargetInvalid)
+	}
+	if target.User != nil {
+		return "", fmt.Errorf("%w: credentials in the url are not allowed", ErrTargetInvalid)
+	}
+
+	target.Host = normalizeHost(target.Scheme, target.Host)
+	if target.Path == "" {
+		target.Path = "/"
+	}
+	for _, self := range policy.SelfHosts {
+		if target.Host == normalizeHost(target.Scheme, self) {
+			return "", fmt.Errorf("%w: %s", ErrTargetSelfReference, target.Host)
+		}
+	}
+	if policy.Blocklist != nil {
+		if rule, blocked := policy.Blocklist.Blocked(target); blocked {
+			return "", fmt.Errorf("%w by rule %q", ErrTargetBlocked, rule)
+		}
+	}
+
+	normalized := target.String()
+	if policy.MaxLength > 0 && len(normalized) > policy.MaxLength {
+		return "", fmt.Errorf("%w: longer than %d characters", ErrTargetInvalid, policy.MaxLength)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,27 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// ApiKey is the stored half of an API key: the plaintext is only ever shown
+// to the user once, the store keeps its hash.
+type ApiKey struct {
+	Id        string    `json:"id"`
+	Hash      string    `json:"hash"`
+	UserId    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func SaveApiKey(ctx context.Context, key *ApiKey) error {
+	return storeService.SaveApiKey(ctx, key)
+}
+
+func RetrieveApiKey(ctx context.Context, id string) (*ApiKey, error) {
+	return storeService.GetApiKey(ctx, id)
+}
+
+func DeleteApiKey(ctx context.Context, id string) error {
+	return storeService.DeleteApiKey(ctx, id)
+}
@@ -0,0 +1,470 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	linksBucket  = []byte("links")
+	usersBucket  = []byte("users")
+	clicksBucket = []byte("clicks")
+	keysBucket   = []byte("api_keys")
+	idemBucket   = []byte("idempotency")
+	seqBucket    = []byte("sequences")
+
+	countersBucket = []byte("counters")
+	visitorsBucket = []byte("visitors")
+	recentKey      = []byte("recent")
+)
+
+// BoltStore persists mappings in a single embedded BoltDB file. The "links"
+// bucket maps short url -> JSON encoded LinkRecord, the "users" bucket holds
+// one nested bucket per owner whose keys are that owner's short urls and the
+// "clicks" bucket one nested bucket per short url with its click counters,
+// the set of visitor hashes and the recent events. "api_keys" maps key id ->
+// JSON encoded ApiKey, "idempotency" maps user id NUL key -> JSON encoded
+// IdempotencyEntry and "sequences" holds one nested bucket per counter, its
+// bolt sequence being the value. Rate limit buckets are not persisted.
+type BoltStore struct {
+	db      *bolt.DB
+	now     func() time.Time
+	buckets tokenBuckets
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{linksBucket, usersBucket, clicksBucket, keysBucket, idemBucket, seqBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		// Files written by earlier versions kept the rate limits on disk.
+		if err := tx.DeleteBucket([]byte("rate_limits")); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db, now: time.Now}, nil
+}
+
+// boltError passes the store sentinels through and reports anything else as
+// the backend being unavailable.
+func boltError(err error) error {
+	if err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, ErrExpired) || errors.Is(err, ErrConflict) {
+		return err
+	}
+	return unavailable(err)
+}
+
+// lookup decodes the record of shortUrl and classifies it with
+// LinkRecord.status. The record is nil only when nothing is stored at all.
+func (s *BoltStore) lookup(tx *bolt.Tx, shortUrl string) (*LinkRecord, error) {
+	data := tx.Bucket(linksBucket).Get([]byte(shortUrl))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	record := &LinkRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	if record.Version == 0 {
+		// Written before records were versioned, in the same layout.
+		record.Version = LinkRecordVersion
+	}
+	return record, record.status(s.now())
+}
+
+// save writes record within tx unless its short url is taken.
+func (s *BoltStore) save(tx *bolt.Tx, record *LinkRecord) error {
+	switch _, err := s.lookup(tx, record.ShortUrl); {
+	case err == nil || errors.Is(err, ErrExpired):
+		return ErrConflict
+	case !errors.Is(err, ErrNotFound):
+		return err
+	}
+	record.Version = LinkRecordVersion
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(linksBucket).Put([]byte(record.ShortUrl), data); err != nil {
+		return err
+	}
+	owned, err := tx.Bucket(usersBucket).CreateBucketIfNotExists([]byte(record.UserId))
+	if err != nil {
+		return err
+	}
+	return owned.Put([]byte(record.ShortUrl), nil)
+}
+
+func (s *BoltStore) Save(ctx context.Context, record *LinkRecord) error {
+	return boltError(s.db.Update(func(tx *bolt.Tx) error {
+		return s.save(tx, record)
+	}))
+}
+
+// SaveBatch writes every record in a single transaction. Conflicts are
+// reported per record, any other failure fails the whole batch.
+func (s *BoltStore) SaveBatch(ctx context.Context, records []*LinkRecord) []error {
+	errs := make([]error, len(records))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for i, record := range records {
+			err := s.save(tx, record)
+			if err != nil && !errors.Is(err, ErrConflict) {
+				return err
+			}
+			errs[i] = err
+		}
+		return nil
+	})
+	if err != nil {
+		for i := range errs {
+			errs[i] = boltError(err)
+		}
+	}
+	return errs
+}
+
+func (s *BoltStore) Get(ctx context.Context, shortUrl string) (*LinkRecord, error) {
+	var record *LinkRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = s.lookup(tx, shortUrl)
+		if record != nil {
+			record.Clicks = s.totalClicks(tx, shortUrl)
+		}
+		return err
+	})
+	switch {
+	case errors.Is(err, ErrExpired):
+		return record, err
+	case err != nil:
+		return nil, boltError(err)
+	}
+	return record, nil
+}
+
+// totalClicks reads the total click counter of shortUrl within tx.
+func (s *BoltStore) totalClicks(tx *bolt.Tx, shortUrl string) int64 {
+	clicks := tx.Bucket(clicksBucket).Bucket([]byte(shortUrl))
+	if clicks == nil {
+		return 0
+	}
+	counters := clicks.Bucket(countersBucket)
+	if counters == nil {
+		return 0
+	}
+	data := counters.Get([]byte(counterTotal))
+	if data == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+func (s *BoltStore) Update(ctx context.Context, record *LinkRecord) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		existing, err := s.lookup(tx, record.ShortUrl)
+		if err != nil {
+			return err
+		}
+		existing.update(record)
+		data, err := json.Marshal(existing)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(linksBucket).Put([]byte(record.ShortUrl), data)
+	})
+	return boltError(err)
+}
+
+func (s *BoltStore) Delete(ctx context.Context, shortUrl string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		record, err := s.lookup(tx, shortUrl)
+		if record == nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		if owned := tx.Bucket(usersBucket).Bucket([]byte(record.UserId)); owned != nil {
+			if err := owned.Delete([]byte(shortUrl)); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(clicksBucket).Bucket([]byte(shortUrl)) != nil {
+			if err := tx.Bucket(clicksBucket).DeleteBucket([]byte(shortUrl)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(linksBucket).Delete([]byte(shortUrl))
+	})
+	return boltError(err)
+}
+
+func (s *BoltStore) Exists(ctx context.Context, shortUrl string) (bool, error) {
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		record, err := s.lookup(tx, shortUrl)
+		found = err == nil
+		if record == nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+	return found, boltError(err)
+}
+
+func (s *BoltStore) ListByUser(ctx context.Context, userId string) ([]string, error) {
+	shortUrls := make([]string, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		owned := tx.Bucket(usersBucket).Bucket([]byte(userId))
+		if owned == nil {
+			return nil
+		}
+		return owned.ForEach(func(k, _ []byte) error {
+			record, err := s.lookup(tx, string(k))
+			if record == nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			if err == nil && record.UserId == userId {
+				shortUrls = append(shortUrls, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, boltError(err)
+	}
+	sort.Strings(shortUrls)
+	return shortUrls, nil
+}
+
+// FindByTarget walks every link of userId, in code order so the first of
+// equally old links stays.
+func (s *BoltStore) FindByTarget(ctx context.Context, userId string, originalUrl string) (*LinkRecord, error) {
+	var found *LinkRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		owned := tx.Bucket(usersBucket).Bucket([]byte(userId))
+		if owned == nil {
+			return ErrNotFound
+		}
+		cursor := owned.Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			record, err := s.lookup(tx, string(k))
+			if record == nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			if err == nil && record.UserId == userId && record.OriginalUrl == originalUrl &&
+				(found == nil || record.CreatedAt.Before(found.CreatedAt)) {
+				found = record
+			}
+		}
+		if found == nil {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, boltError(err)
+	}
+	return found, nil
+}
+
+func (s *BoltStore) NextSequence(ctx context.Context, name string) (uint64, error) {
+	var value uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		sequence, err := tx.Bucket(seqBucket).CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		value, err = sequence.NextSequence()
+		return err
+	})
+	return value, boltError(err)
+}
+
+func (s *BoltStore) RecordClick(ctx context.Context, event *ClickEvent) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		clicks, err := tx.Bucket(clicksBucket).CreateBucketIfNotExists([]byte(event.ShortUrl))
+		if err != nil {
+			return err
+		}
+		counters, err := clicks.CreateBucketIfNotExists(countersBucket)
+		if err != nil {
+			return err
+		}
+		for _, counter := range event.counters() {
+			value := uint64(0)
+			if data := counters.Get([]byte(counter)); data != nil {
+				value = binary.BigEndian.Uint64(data)
+			}
+			if err := counters.Put([]byte(counter), binary.BigEndian.AppendUint64(nil, value+1)); err != nil {
+				return err
+			}
+		}
+
+		if event.IPHash != "" {
+			visitors, err := clicks.CreateBucketIfNotExists(visitorsBucket)
+			if err != nil {
+				return err
+			}
+			if err := visitors.Put([]byte(event.IPHash), nil); err != nil {
+				return err
+			}
+		}
+
+		var recent []ClickEvent
+		if data := clicks.Get(recentKey); data != nil {
+			if err := json.Unmarshal(data, &recent); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(prependRecent(recent, *event))
+		if err != nil {
+			return err
+		}
+		return clicks.Put(recentKey, data)
+	})
+	return boltError(err)
+}
+
+func (s *BoltStore) ClickStats(ctx context.Context, shortUrl string) (*ClickStats, error) {
+	values := map[string]int64{}
+	var (
+		visitors int64
+		recent   []ClickEvent
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		clicks := tx.Bucket(clicksBucket).Bucket([]byte(shortUrl))
+		if clicks == nil {
+			return nil
+		}
+		if counters := clicks.Bucket(countersBucket); counters != nil {
+			err := counters.ForEach(func(k, v []byte) error {
+				values[string(k)] = int64(binary.BigEndian.Uint64(v))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if visitorSet := clicks.Bucket(visitorsBucket); visitorSet != nil {
+			visitors = int64(visitorSet.Stats().KeyN)
+		}
+		if data := clicks.Get(recentKey); data != nil {
+			return json.Unmarshal(data, &recent)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, boltError(err)
+	}
+	return newClickStats(values, visitors, recent), nil
+}
+
+func (s *BoltStore) SaveApiKey(ctx context.Context, key *ApiKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(keysBucket)
+		if keys.Get([]byte(key.Id)) != nil {
+			return ErrConflict
+		}
+		return keys.Put([]byte(key.Id), data)
+	})
+	return boltError(err)
+}
+
+func (s *BoltStore) GetApiKey(ctx context.Context, id string) (*ApiKey, error) {
+	key := &ApiKey{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(keysBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, key)
+	})
+	if err != nil {
+		return nil, boltError(err)
+	}
+	return key, nil
+}
+
+func (s *BoltStore) DeleteApiKey(ctx context.Context, id string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(keysBucket)
+		if keys.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return keys.Delete([]byte(id))
+	})
+	return boltError(err)
+}
+
+// TakeTokens keeps its buckets in memory: only one process ever opens the
+// file, and writing them would sync the file on every redirect.
+func (s *BoltStore) TakeTokens(ctx context.Context, key string, n int, limit RateLimit) (bool, time.Duration, error) {
+	ok, wait := s.buckets.take(key, n, limit, s.now())
+	return ok, wait, nil
+}
+
+func (s *BoltStore) SaveIdempotencyKey(ctx context.Context, entry *IdempotencyEntry) error {
+	entry.ExpiresAt = s.now().Add(IdempotencyKeyTTL)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(idemBucket).Put([]byte(idempotencyId(entry.UserId, entry.Key)), data)
+	})
+	return boltError(err)
+}
+
+// GetIdempotencyKey ignores expired entries, they are left to be overwritten.
+func (s *BoltStore) GetIdempotencyKey(ctx context.Context, userId string, key string) (*IdempotencyEntry, error) {
+	entry := &IdempotencyEntry{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(idemBucket).Get([]byte(idempotencyId(userId, key)))
+		if data == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(data, entry); err != nil {
+			return err
+		}
+		if !entry.ExpiresAt.After(s.now()) {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, boltError(err)
+	}
+	return entry, nil
+}
+
+func (s *BoltStore) Ping(ctx context.Context) error {
+	return unavailable(s.db.View(func(tx *bolt.Tx) error {
+		return nil
+	}))
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,347 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen fails operations without reaching a backend that its Breaker
+// considers unhealthy.
+var ErrCircuitOpen = fmt.Errorf("%w: circuit breaker open", ErrBackendUnavailable)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// Breaker opens after threshold consecutive backend failures and rejects
+// every call for cooldown. It then lets a single probe through, whose success
+// closes it again and whose failure reopens it.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	// onChange is told about every opening and closing.
+	onChange func(open bool)
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// callOutcome is what a call through the breaker tells about the backend.
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	// callAbandoned calls were given up by their caller, which says nothing
+	// about the backend.
+	callAbandoned
+)
+
+// allow reports whether a call may go to the backend. A nil Breaker allows
+// everything.
+func (b *Breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// done records the outcome of a call allow let through.
+func (b *Breaker) done(outcome callOutcome) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerClosed:
+		switch outcome {
+		case callSucceeded:
+			b.failures = 0
+		case callFailed:
+			b.failures++
+			if b.failures >= b.threshold {
+				b.open()
+			}
+		}
+	case breakerHalfOpen:
+		// Only the probe is let through in this state.
+		b.probing = false
+		switch outcome {
+		case callSucceeded:
+			b.state = breakerClosed
+			b.failures = 0
+			if b.onChange != nil {
+				b.onChange(false)
+			}
+		case callFailed:
+			b.open()
+		}
+	}
+}
+
+// open must be called with b.mu held.
+func (b *Breaker) open() {
+	b.state = breakerOpen
+	b.openedAt = b.now()
+	if b.onChange != nil {
+		b.onChange(true)
+	}
+}
+
+// Open reports whether calls are currently rejected.
+func (b *Breaker) Open() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != breakerClosed
+}
+
+// GuardedStore bounds every operation of the Store it wraps with a deadline,
+// readTimeout for reads and writeTimeout for writes, and fails them fast with
+// ErrCircuitOpen while its Breaker is open. Zero timeouts set no deadline
+// and a nil Breaker never opens.
+type GuardedStore struct {
+	backend      string
+	store        Store
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	breaker      *Breaker
+}
+
+func NewGuardedStore(backend string, s Store, readTimeout time.Duration, writeTimeout time.Duration, breaker *Breaker) *GuardedStore {
+	if breaker != nil {
+		breaker.onChange = func(open bool) {
+			if open {
+				circuitOpen.WithLabelValues(backend).Set(1)
+				slog.Warn("store circuit breaker opened", "backend", backend, "cooldown", breaker.cooldown)
+			} else {
+				circuitOpen.WithLabelValues(backend).Set(0)
+				slog.Info("store circuit breaker closed", "backend", backend)
+			}
+		}
+	}
+	return &GuardedStore{backend: backend, store: s, readTimeout: readTimeout, writeTimeout: writeTimeout, breaker: breaker}
+}
+
+// backendFailure tells the errors of an unhealthy backend apart from the
+// answers of a healthy one.
+func backendFailure(err error) bool {
+	return errors.Is(err, ErrBackendUnavailable) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// call runs operation under the deadline of timeout, unless the breaker
+// rejects it. The errors of operations the caller gave up on do not count
+// against the backend.
+func (s *GuardedStore) call(ctx context.Context, timeout time.Duration, operation func(ctx context.Context) []error) []error {
+	if !s.breaker.allow() {
+		return []error{ErrCircuitOpen}
+	}
+	operationCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		operationCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	errs := operation(operationCtx)
+
+	outcome := callSucceeded
+	for i, err := range errs {
+		if err == nil || !backendFailure(err) {
+			continue
+		}
+		if ctx.Err() != nil {
+			outcome = callAbandoned
+			break
+		}
+		outcome = callFailed
+		if !errors.Is(err, ErrBackendUnavailable) {
+			errs[i] = unavailable(err)
+		}
+	}
+	s.breaker.done(outcome)
+	return errs
+}
+
+func (s *GuardedStore) read(ctx context.Context, operation func(ctx context.Context) error) error {
+	return s.call(ctx, s.readTimeout, func(ctx context.Context) []error { return []error{operation(ctx)} })[0]
+}
+
+func (s *GuardedStore) write(ctx context.Context, operation func(ctx context.Context) error) error {
+	return s.call(ctx, s.writeTimeout, func(ctx context.Context) []error { return []error{operation(ctx)} })[0]
+}
+
+func (s *GuardedStore) Save(ctx context.Context, record *LinkRecord) error {
+	return s.write(ctx, func(ctx context.Context) error {
+		return s.store.Save(ctx, record)
+	})
+}
+
+func (s *GuardedStore) SaveBatch(ctx context.Context, records []*LinkRecord) []error {
+	errs := s.call(ctx, s.writeTimeout, func(ctx context.Context) []error {
+		return s.store.SaveBatch(ctx, records)
+	})
+	if len(errs) != len(records) {
+		// Rejected by the breaker.
+		rejected := make([]error, len(records))
+		for i := range rejected {
+			rejected[i] = errs[0]
+		}
+		return rejected
+	}
+	return errs
+}
+
+func (s *GuardedStore) Get(ctx context.Context, shortUrl string) (record *LinkRecord, err error) {
+	err = s.read(ctx, func(ctx context.Context) error {
+		record, err = s.store.Get(ctx, shortUrl)
+		return err
+	})
+	return record, err
+}
+
+func (s *GuardedStore) Update(ctx context.Context, record *LinkRecord) error {
+	return s.write(ctx, func(ctx context.Context) error {
+		return s.store.Update(ctx, record)
+	})
+}
+
+func (s *GuardedStore) Delete(ctx context.Context, shortUrl string) error {
+	return s.write(ctx, func(ctx context.Context) error {
+		return s.store.Delete(ctx, shortUrl)
+	})
+}
+
+func (s *GuardedStore) Exists(ctx context.Context, shortUrl string) (exists bool, err error) {
+	err = s.read(ctx, func(ctx context.Context) error {
+		exists, err = s.store.Exists(ctx, shortUrl)
+		return err
+	})
+	return exists, err
+}
+
+func (s *GuardedStore) ListByUser(ctx context.Context, userId string) (codes []string, err error) {
+	err = s.read(ctx, func(ctx context.Context) error {
+		codes, err = s.store.ListByUser(ctx, userId)
+		return err
+	})
+	return codes, err
+}
+
+func (s *GuardedStore) FindByTarget(ctx context.Context, userId string, originalUrl string) (record *LinkRecord, err error) {
+	err = s.read(ctx, func(ctx context.Context) error {
+		record, err = s.store.FindByTarget(ctx, userId, originalUrl)
+		return err
+	})
+	return record, err
+}
+
+func (s *GuardedStore) NextSequence(ctx context.Context, name string) (next uint64, err error) {
+	err = s.write(ctx, func(ctx context.Context) error {
+		next, err = s.store.NextSequence(ctx, name)
+		return err
+	})
+	return next, err
+}
+
+func (s *GuardedStore) RecordClick(ctx context.Context, event *ClickEvent) error {
+	return s.write(ctx, func(ctx context.Context) error {
+		return s.store.RecordClick(ctx, event)
+	})
+}
+
+func (s *GuardedStore) ClickStats(ctx context.Context, shortUrl string) (stats *ClickStats, err error) {
+	err = s.read(ctx, func(ctx context.Context) error {
+		stats, err = s.store.ClickStats(ctx, shortUrl)
+		return err
+	})
+	return stats, err
+}
+
+func (s *GuardedStore) SaveApiKey(ctx context.Context, key *ApiKey) error {
+	return s.write(ctx, func(ctx context.Context) error {
+		return s.store.SaveApiKey(ctx, key)
+	})
+}
+
+func (s *GuardedStore) GetApiKey(ctx context.Context, id string) (key *ApiKey, err error) {
+	err = s.read(ctx, func(ctx context.Context) error {
+		key, err = s.store.GetApiKey(ctx, id)
+		return err
+	})
+	return key, err
+}
+
+func (s *GuardedStore) DeleteApiKey(ctx context.Context, id string) error {
+	return s.write(ctx, func(ctx context.Context) error {
+		return s.store.DeleteApiKey(ctx, id)
+	})
+}
+
+func (s *GuardedStore) TakeTokens(ctx context.Context, key string, n int, limit RateLimit) (allowed bool, retryAfter time.Duration, err error) {
+	err = s.write(ctx, func(ctx context.Context) error {
+		allowed, retryAfter, err = s.store.TakeTokens(ctx, key, n, limit)
+		return err
+	})
+	return allowed, retryAfter, err
+}
+
+func (s *GuardedStore) SaveIdempotencyKey(ctx context.Context, entry *IdempotencyEntry) error {
+	return s.write(ctx, func(ctx context.Context) error {
+		return s.store.SaveIdempotencyKey(ctx, entry)
+	})
+}
+
+func (s *GuardedStore) GetIdempotencyKey(ctx context.Context, userId string, key string) (entry *IdempotencyEntry, err error) {
+	err = s.read(ctx, func(ctx context.Context) error {
+		entry, err = s.store.GetIdempotencyKey(ctx, userId, key)
+		return err
+	})
+	return entry, err
+}
+
+func (s *GuardedStore) Ping(ctx context.Context) error {
+	return s.read(ctx, func(ctx context.Context) error {
+		return s.store.Ping(ctx)
+	})
+}
+
+func (s *GuardedStore) Close() error {
+	return s.store.Close()
+}
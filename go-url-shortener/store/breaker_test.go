@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyStore fails its reads while down is set and makes them wait for delay,
+// giving up when their context is done as Redis does.
+type flakyStore struct {
+	Store
+	down  atomic.Bool
+	delay time.Duration
+	gets  atomic.Int64
+}
+
+func (s *flakyStore) Get(ctx context.Context, shortUrl string) (*LinkRecord, error) {
+	s.gets.Add(1)
+	if s.down.Load() {
+		return nil, unavailable(errors.New("connection refused"))
+	}
+	select {
+	case <-time.After(s.delay):
+		return s.Store.Get(ctx, shortUrl)
+	case <-ctx.Done():
+		return nil, unavailable(ctx.Err())
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	breaker := NewBreaker(2, time.Second)
+	breaker.now = clock.Now
+	var changes []bool
+	breaker.onChange = func(open bool) { changes = append(changes, open) }
+
+	fail := func() {
+		require.True(t, breaker.allow())
+		breaker.done(callFailed)
+	}
+	fail()
+	require.True(t, breaker.allow())
+	breaker.done(callSucceeded)
+	fail()
+	assert.False(t, breaker.Open(), "only consecutive failures open the breaker")
+	fail()
+	assert.True(t, breaker.Open())
+	assert.False(t, breaker.allow())
+
+	clock.now = clock.now.Add(time.Second)
+	require.True(t, breaker.allow(), "a probe goes through after the cooldown")
+	assert.False(t, breaker.allow(), "one probe at a time")
+	breaker.done(callFailed)
+	assert.False(t, breaker.allow(), "a failed probe reopens the breaker")
+
+	clock.now = clock.now.Add(time.Second)
+	require.True(t, breaker.allow())
+	breaker.done(callAbandoned)
+	require.True(t, breaker.allow(), "an abandoned probe lets another one through")
+	breaker.done(callSucceeded)
+	assert.False(t, breaker.Open())
+	assert.True(t, breaker.allow())
+	assert.Equal(t, []bool{true, true, false}, changes)
+
+	var disabled *Breaker
+	assert.True(t, disabled.allow())
+	disabled.done(callFailed)
+	assert.False(t, disabled.Open())
+}
+
+func TestGuardedStoreTimeout(t *testing.T) {
+	backend := &flakyStore{Store: NewMemoryStore(), delay: time.Second}
+	s := NewGuardedStore(BackendMemory, backend, 10*time.Millisecond, time.Second, nil)
+	ctx := context.Background()
+
+	start := time.Now()
+	_, err := s.Get(ctx, "slow")
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	backend.delay = 0
+	require.NoError(t, s.Save(ctx, &LinkRecord{ShortUrl: "fast", OriginalUrl: "https://example.com", UserId: "owner", CreatedAt: time.Now()}))
+	record, err := s.Get(ctx, "fast")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", record.OriginalUrl)
+}
+
+func TestGuardedStoreBreaker(t *testing.T) {
+	backend := &flakyStore{Store: NewMemoryStore(), delay: time.Second}
+	clock := &fakeClock{now: time.Now()}
+	breaker := NewBreaker(3, time.Second)
+	breaker.now = clock.Now
+	s := NewGuardedStore(BackendMemory, backend, 50*time.Millisecond, time.Second, breaker)
+
+	// Callers going away say nothing about the backend.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		_, err := s.Get(canceled, "gone")
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+	assert.False(t, breaker.Open())
+
+	ctx := context.Background()
+	backend.down.Store(true)
+	for i := 0; i < 3; i++ {
+		_, err := s.Get(ctx, "abc")
+		assert.ErrorIs(t, err, ErrBackendUnavailable)
+	}
+	require.True(t, breaker.Open())
+
+	_, err := s.Get(ctx, "abc")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.EqualValues(t, 6, backend.gets.Load(), "open breakers do not reach the backend")
+	errs := s.SaveBatch(ctx, []*LinkRecord{{ShortUrl: "a"}, {ShortUrl: "b"}})
+	assert.Equal(t, []error{ErrCircuitOpen, ErrCircuitOpen}, errs)
+
+	backend.down.Store(false)
+	backend.delay = 0
+	clock.now = clock.now.Add(time.Second)
+	_, err = s.Get(ctx, "abc")
+	assert.ErrorIs(t, err, ErrNotFound, "the probe reaches the backend")
+	assert.False(t, breaker.Open())
+}
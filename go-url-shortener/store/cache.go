@@ -0,0 +1,211 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CachedStore is a read-through LRU cache of links in front of another
+// Store. Concurrent misses of a short url share a single backend read, and
+// unknown short urls are remembered for a shorter while so scans of random
+// codes do not reach the backend either. Writes going through the cache
+// invalidate what it holds; writes of other instances only show once the
+// entries run out, after at most TTL (NegativeTTL for unknown codes). Clicks
+// of cached records lag behind by as much.
+type CachedStore struct {
+	Store
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// generation is bumped by every invalidation, so that reads started
+	// before it do not cache what they found.
+	generation uint64
+	group      singleflight.Group
+
+	hits, negativeHits, misses, evictions atomic.Uint64
+}
+
+// CacheStats are the counters of a CachedStore since it was created.
+// NegativeHits are the part of Hits answered with ErrNotFound.
+type CacheStats struct {
+	Hits         uint64
+	NegativeHits uint64
+	Misses       uint64
+	Evictions    uint64
+	Entries      int
+}
+
+type cacheEntry struct {
+	shortUrl string
+	record   *LinkRecord
+	err      error
+	expires  time.Time
+}
+
+// NewCachedStore caches up to size links of backend for ttl, unknown short
+// urls for negativeTTL; a zero negativeTTL disables negative caching.
+func NewCachedStore(backend Store, size int, ttl time.Duration, negativeTTL time.Duration) *CachedStore {
+	return &CachedStore{
+		Store:       backend,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// copyRecord keeps callers from changing the cached records.
+func copyRecord(record *LinkRecord) *LinkRecord {
+	if record == nil {
+		return nil
+	}
+	copied := *record
+	copied.Tags = append([]string(nil), record.Tags...)
+	return &copied
+}
+
+func (s *CachedStore) Get(ctx context.Context, shortUrl string) (*LinkRecord, error) {
+	if record, err, ok := s.cached(shortUrl); ok {
+		return record, err
+	}
+	s.misses.Add(1)
+
+	s.mu.Lock()
+	generation := s.generation
+	s.mu.Unlock()
+	// The read shared by concurrent misses runs under the context of the
+	// first of them, which is not cut short when that caller goes away.
+	shared := context.WithoutCancel(ctx)
+	value, err, _ := s.group.Do(shortUrl, func() (interface{}, error) {
+		record, err := s.Store.Get(shared, shortUrl)
+		s.remember(shortUrl, record, err, generation)
+		return record, err
+	})
+	record, _ := value.(*LinkRecord)
+	return copyRecord(record), err
+}
+
+// cached returns the live cache entry of shortUrl.
+func (s *CachedStore) cached(shortUrl string) (*LinkRecord, error, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[shortUrl]
+	if !ok {
+		return nil, nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !s.now().Before(entry.expires) {
+		s.remove(element)
+		return nil, nil, false
+	}
+	s.lru.MoveToFront(element)
+	s.hits.Add(1)
+	if entry.err == ErrNotFound {
+		s.negativeHits.Add(1)
+	}
+	return copyRecord(entry.record), entry.err, true
+}
+
+// remember caches the outcome of reading shortUrl unless the backend failed,
+// the cache was invalidated since generation or a live link is about to
+// expire anyway.
+func (s *CachedStore) remember(shortUrl string, record *LinkRecord, err error, generation uint64) {
+	now := s.now()
+	expires := now.Add(s.ttl)
+	switch {
+	case err == nil:
+		if !record.ExpiresAt.IsZero() && record.ExpiresAt.Before(expires) {
+			expires = record.ExpiresAt
+		}
+	case errors.Is(err, ErrExpired):
+	case errors.Is(err, ErrNotFound):
+		expires = now.Add(s.negativeTTL)
+	default:
+		return
+	}
+	if !expires.After(now) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.generation != generation {
+		return
+	}
+	if element, ok := s.entries[shortUrl]; ok {
+		s.remove(element)
+	}
+	s.entries[shortUrl] = s.lru.PushFront(&cacheEntry{shortUrl: shortUrl, record: copyRecord(record), err: err, expires: expires})
+	for s.lru.Len() > s.size {
+		s.remove(s.lru.Back())
+		s.evictions.Add(1)
+	}
+}
+
+// remove must be called with s.mu held.
+func (s *CachedStore) remove(element *list.Element) {
+	s.lru.Remove(element)
+	delete(s.entries, element.Value.(*cacheEntry).shortUrl)
+}
+
+// Invalidate drops shortUrl from the cache.
+func (s *CachedStore) Invalidate(shortUrl string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	if element, ok := s.entries[shortUrl]; ok {
+		s.remove(element)
+	}
+}
+
+func (s *CachedStore) Save(ctx context.Context, record *LinkRecord) error {
+	err := s.Store.Save(ctx, record)
+	s.Invalidate(record.ShortUrl)
+	return err
+}
+
+func (s *CachedStore) SaveBatch(ctx context.Context, records []*LinkRecord) []error {
+	errs := s.Store.SaveBatch(ctx, records)
+	for _, record := range records {
+		s.Invalidate(record.ShortUrl)
+	}
+	return errs
+}
+
+func (s *CachedStore) Update(ctx context.Context, record *LinkRecord) error {
+	err := s.Store.Update(ctx, record)
+	s.Invalidate(record.ShortUrl)
+	return err
+}
+
+func (s *CachedStore) Delete(ctx context.Context, shortUrl string) error {
+	err := s.Store.Delete(ctx, shortUrl)
+	s.Invalidate(shortUrl)
+	return err
+}
+
+func (s *CachedStore) Stats() CacheStats {
+	s.mu.Lock()
+	entries := s.lru.Len()
+	s.mu.Unlock()
+	return CacheStats{
+		Hits:         s.hits.Load(),
+		NegativeHits: s.negativeHits.Load(),
+		Misses:       s.misses.Load(),
+		Evictions:    s.evictions.Load(),
+		Entries:      entries,
+	}
+}
@@ -0,0 +1,261 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts the reads reaching the backend, holding them until
+// release is closed when it is set.
+type countingStore struct {
+	Store
+	gets    atomic.Int64
+	release chan struct{}
+}
+
+func (s *countingStore) Get(ctx context.Context, shortUrl string) (*LinkRecord, error) {
+	s.gets.Add(1)
+	if s.release != nil {
+		<-s.release
+	}
+	return s.Store.Get(ctx, shortUrl)
+}
+
+// newTestCache puts a cache of size links on a memory store, both running on
+// clock.
+func newTestCache(size int) (*CachedStore, *countingStore, *fakeClock) {
+	clock := &fakeClock{now: time.Now()}
+	memoryStore := NewMemoryStore()
+	memoryStore.now = clock.Now
+	backend := &countingStore{Store: memoryStore}
+	cache := NewCachedStore(backend, size, time.Minute, 10*time.Second)
+	cache.now = clock.Now
+	return cache, backend, clock
+}
+
+func cacheRecord(shortUrl string, clock *fakeClock) *LinkRecord {
+	return &LinkRecord{
+		ShortUrl:    shortUrl,
+		OriginalUrl: "https://example.com/" + shortUrl,
+		UserId:      "owner",
+		CreatedAt:   clock.now,
+		ExpiresAt:   clock.now.Add(time.Hour),
+		Tags:        []string{"docs"},
+	}
+}
+
+func TestCachedStoreReadThrough(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, clock := newTestCache(10)
+	require.NoError(t, cache.Save(ctx, cacheRecord("cached", clock)))
+
+	for i := 0; i < 3; i++ {
+		record, err := cache.Get(ctx, "cached")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/cached", record.OriginalUrl)
+	}
+	assert.EqualValues(t, 1, backend.gets.Load())
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1, Entries: 1}, cache.Stats())
+
+	// Callers get copies they are free to change.
+	record, _ := cache.Get(ctx, "cached")
+	record.OriginalUrl = "https://example.com/changed"
+	record.Tags[0] = "changed"
+	record, _ = cache.Get(ctx, "cached")
+	assert.Equal(t, "https://example.com/cached", record.OriginalUrl)
+	assert.Equal(t, []string{"docs"}, record.Tags)
+
+	clock.now = clock.now.Add(time.Minute)
+	_, err := cache.Get(ctx, "cached")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, backend.gets.Load(), "entries expire after the ttl")
+}
+
+func TestCachedStoreNegative(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, clock := newTestCache(10)
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.Get(ctx, "unknown")
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	assert.EqualValues(t, 1, backend.gets.Load())
+	assert.EqualValues(t, 1, cache.Stats().NegativeHits)
+
+	clock.now = clock.now.Add(10 * time.Second)
+	_, err := cache.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.EqualValues(t, 2, backend.gets.Load(), "unknown codes are cached for the negative ttl")
+
+	// Saving through the cache forgets the code was unknown.
+	require.NoError(t, cache.Save(ctx, cacheRecord("unknown", clock)))
+	_, err = cache.Get(ctx, "unknown")
+	assert.NoError(t, err)
+
+	cache.negativeTTL = 0
+	_, err = cache.Get(ctx, "other")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 1, cache.Stats().Entries)
+}
+
+func TestCachedStoreInvalidation(t *testing.T) {
+	ctx := context.Background()
+	cache, _, clock := newTestCache(10)
+	record := cacheRecord("changing", clock)
+	require.NoError(t, cache.Save(ctx, record))
+	_, err := cache.Get(ctx, "changing")
+	require.NoError(t, err)
+
+	record.OriginalUrl = "https://example.com/updated"
+	require.NoError(t, cache.Update(ctx, record))
+	stored, err := cache.Get(ctx, "changing")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/updated", stored.OriginalUrl)
+
+	require.NoError(t, cache.Delete(ctx, "changing"))
+	_, err = cache.Get(ctx, "changing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	errs := cache.SaveBatch(ctx, []*LinkRecord{cacheRecord("changing", clock)})
+	require.NoError(t, errs[0])
+	_, err = cache.Get(ctx, "changing")
+	assert.NoError(t, err)
+}
+
+func TestCachedStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, clock := newTestCache(10)
+	record := cacheRecord("short-lived", clock)
+	record.ExpiresAt = clock.now.Add(time.Second)
+	require.NoError(t, cache.Save(ctx, record))
+
+	_, err := cache.Get(ctx, "short-lived")
+	require.NoError(t, err)
+	clock.now = clock.now.Add(time.Second)
+	_, err = cache.Get(ctx, "short-lived")
+	assert.ErrorIs(t, err, ErrExpired, "links are not served from the cache past their expiry")
+	_, err = cache.Get(ctx, "short-lived")
+	assert.ErrorIs(t, err, ErrExpired)
+	assert.EqualValues(t, 2, backend.gets.Load(), "expired links are cached too")
+}
+
+func TestCachedStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, clock := newTestCache(2)
+	for _, code := range []string{"a", "b", "c"} {
+		require.NoError(t, cache.Save(ctx, cacheRecord(code, clock)))
+	}
+
+	cache.Get(ctx, "a")
+	cache.Get(ctx, "b")
+	cache.Get(ctx, "a")
+	cache.Get(ctx, "c") // evicts b, the least recently used
+	assert.EqualValues(t, 3, backend.gets.Load())
+
+	cache.Get(ctx, "a")
+	cache.Get(ctx, "c")
+	assert.EqualValues(t, 3, backend.gets.Load())
+	cache.Get(ctx, "b")
+	assert.EqualValues(t, 4, backend.gets.Load())
+	assert.EqualValues(t, 2, cache.Stats().Evictions)
+	assert.Equal(t, 2, cache.Stats().Entries)
+}
+
+func TestCachedStoreCoalescesMisses(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, clock := newTestCache(10)
+	require.NoError(t, cache.Save(ctx, cacheRecord("popular", clock)))
+	backend.release = make(chan struct{})
+
+	const readers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record, err := cache.Get(ctx, "popular")
+			assert.NoError(t, err)
+			assert.Equal(t, "popular", record.ShortUrl)
+		}()
+	}
+	require.Eventually(t, func() bool { return cache.Stats().Misses == readers }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, backend.gets.Load())
+}
+
+func TestCachedStoreStaleRead(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, clock := newTestCache(10)
+	record := cacheRecord("racing", clock)
+	require.NoError(t, cache.Save(ctx, record))
+	backend.release = make(chan struct{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.Get(ctx, "racing")
+	}()
+	require.Eventually(t, func() bool { return backend.gets.Load() == 1 }, time.Second, time.Millisecond)
+	// An update landing while the backend is read may not be hidden by what
+	// that read returns.
+	cache.Invalidate("racing")
+	close(backend.release)
+	<-done
+
+	assert.Equal(t, 0, cache.Stats().Entries)
+}
+
+// BenchmarkRedisGet compares reads of the same link from Redis with and
+// without the cache.
+func BenchmarkRedisGet(b *testing.B) {
+	ctx := context.Background()
+	mr := miniredis.RunT(b)
+	redisStore, err := NewRedisStore(mr.Addr(), "", 0)
+	require.NoError(b, err)
+	defer redisStore.Close()
+	require.NoError(b, redisStore.Save(ctx, &LinkRecord{
+		ShortUrl: "bench", OriginalUrl: "https://example.com/bench", UserId: "owner", CreatedAt: time.Now(),
+	}))
+
+	for name, s := range map[string]Store{
+		"uncached": redisStore,
+		"cached":   NewCachedStore(redisStore, 1000, time.Minute, time.Second),
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := s.Get(ctx, "bench"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkCachedStoreMisses reads b.N distinct links through a cache too
+// small to hold them.
+func BenchmarkCachedStoreMisses(b *testing.B) {
+	ctx := context.Background()
+	cache, _, clock := newTestCache(100)
+	for i := 0; i < 1000; i++ {
+		require.NoError(b, cache.Save(ctx, cacheRecord(strconv.Itoa(i), clock)))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cache.Get(ctx, strconv.Itoa(i%1000)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// ClickEvent is one redirect as recorded by the analytics pipeline. The
+// visitor's IP address only ever reaches the store hashed.
+type ClickEvent struct {
+	ShortUrl  string    `json:"short_url"`
+	Timestamp time.Time `json:"timestamp"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	IPHash    string    `json:"ip_hash,omitempty"`
+}
+
+// ClickStats aggregates the clicks of one short url. Hourly and Daily are keyed
+// by the UTC start of the bucket formatted with HourLayout and DayLayout.
+type ClickStats struct {
+	Total          int64
+	UniqueVisitors int64
+	Hourly         map[string]int64
+	Daily          map[string]int64
+	Countries      map[string]int64
+	Referrers      map[string]int64
+	Recent         []ClickEvent
+}
+
+const (
+	HourLayout = "2006-01-02T15"
+	DayLayout  = "2006-01-02"
+
+	// MaxRecentClicks is how many raw events are kept per short url, newest
+	// first.
+	MaxRecentClicks = 100
+)
+
+const (
+	counterTotal    = "total"
+	counterHour     = "hour:"
+	counterDay      = "day:"
+	counterCountry  = "country:"
+	counterReferrer = "referrer:"
+)
+
+// counters lists the named counters a click increments. Backends store them
+// as plain integers and rebuild ClickStats with newClickStats.
+func (e *ClickEvent) counters() []string {
+	ts := e.Timestamp.UTC()
+	counters := []string{
+		counterTotal,
+		counterHour + ts.Format(HourLayout),
+		counterDay + ts.Format(DayLayout),
+	}
+	if e.Country != "" {
+		counters = append(counters, counterCountry+e.Country)
+	}
+	if e.Referrer != "" {
+		counters = append(counters, counterReferrer+e.Referrer)
+	}
+	return counters
+}
+
+func newClickStats(counters map[string]int64, uniqueVisitors int64, recent []ClickEvent) *ClickStats {
+	stats := &ClickStats{
+		UniqueVisitors: uniqueVisitors,
+		Hourly:         map[string]int64{},
+		Daily:          map[string]int64{},
+		Countries:      map[string]int64{},
+		Referrers:      map[string]int64{},
+		Recent:         recent,
+	}
+	for name, value := range counters {
+		switch {
+		case name == counterTotal:
+			stats.Total = value
+		case strings.HasPrefix(name, counterHour):
+			stats.Hourly[strings.TrimPrefix(name, counterHour)] = value
+		case strings.HasPrefix(name, counterDay):
+			stats.Daily[strings.TrimPrefix(name, counterDay)] = value
+		case strings.HasPrefix(name, counterCountry):
+			stats.Countries[strings.TrimPrefix(name, counterCountry)] = value
+		case strings.HasPrefix(name, counterReferrer):
+			stats.Referrers[strings.TrimPrefix(name, counterReferrer)] = value
+		}
+	}
+	if stats.Recent == nil {
+		stats.Recent = []ClickEvent{}
+	}
+	return stats
+}
+
+// prependRecent adds event in front of recent, capped at MaxRecentClicks.
+func prependRecent(recent []ClickEvent, event ClickEvent) []ClickEvent {
+	recent = append([]ClickEvent{event}, recent...)
+	if len(recent) > MaxRecentClicks {
+		recent = recent[:MaxRecentClicks]
+	}
+	return recent
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyKeyTTL is how long a client may retry a creation with the same
+// Idempotency-Key header and get the original link back.
+var IdempotencyKeyTTL = 24 * time.Hour
+
+// IdempotencyEntry remembers which short url a user's Idempotency-Key
+// produced. Fingerprint identifies the request it was first used with.
+type IdempotencyEntry struct {
+	UserId      string    `json:"user_id"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	ShortUrl    string    `json:"short_url"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func idempotencyId(userId string, key string) string {
+	return userId + "\x00" + key
+}
+
+// SaveIdempotencyKey stores entry until IdempotencyKeyTTL from now, replacing
+// an earlier entry for the same user and key.
+func SaveIdempotencyKey(ctx context.Context, entry *IdempotencyEntry) error {
+	return storeService.SaveIdempotencyKey(ctx, entry)
+}
+
+// RetrieveIdempotencyKey fails with ErrNotFound once the entry has expired.
+func RetrieveIdempotencyKey(ctx context.Context, userId string, key string) (*IdempotencyEntry, error) {
+	return storeService.GetIdempotencyKey(ctx, userId, key)
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// LinkRecordVersion is the layout every backend writes records with. Version
+// 1 is the bare "short url -> original url" string of the first releases,
+// which the Redis backend still reads and upgrades.
+const LinkRecordVersion = 2
+
+// LinkRecord is everything the store keeps about one short url. A zero
+// ExpiresAt means the link never expires. Interstitial links show a page
+// naming their destination, and Title when set, instead of redirecting
+// straight away. A zero RedirectStatus redirects with DefaultRedirectStatus.
+// Links with a PasswordHash, a bcrypt hash, are only followed once the
+// password has been given. Disabled links are kept but not followed. Clicks
+// is not stored with the record but filled from the click counters by Get.
+type LinkRecord struct {
+	Version        int       `json:"version"`
+	ShortUrl       string    `json:"short_url"`
+	OriginalUrl    string    `json:"original_url"`
+	UserId         string    `json:"user_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	Title          string    `json:"title,omitempty"`
+	Interstitial   bool      `json:"interstitial,omitempty"`
+	RedirectStatus int       `json:"redirect_status,omitempty"`
+	PasswordHash   string    `json:"password_hash,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`
+	Disabled       bool      `json:"disabled,omitempty"`
+	Clicks         int64     `json:"-"`
+}
+
+// update copies onto r the fields Store.Update may change.
+func (r *LinkRecord) update(changes *LinkRecord) {
+	r.OriginalUrl = changes.OriginalUrl
+	r.ExpiresAt = changes.ExpiresAt
+	r.Title = changes.Title
+	r.Interstitial = changes.Interstitial
+	r.RedirectStatus = changes.RedirectStatus
+	r.PasswordHash = changes.PasswordHash
+	r.Tags = append([]string(nil), changes.Tags...)
+	r.Disabled = changes.Disabled
+}
+
+const DefaultRedirectStatus = http.StatusFound
+
+// RedirectCode is the HTTP status visitors are redirected with.
+func (r *LinkRecord) RedirectCode() int {
+	if r.RedirectStatus == 0 {
+		return DefaultRedirectStatus
+	}
+	return r.RedirectStatus
+}
+
+// Equivalent reports whether other can be handed out in place of r: the same
+// url of the same user, presented and tagged the same way and enabled alike.
+// Codes and dates may differ. Password hashes are salted, so protected links
+// are never equivalent to a freshly requested one.
+func (r *LinkRecord) Equivalent(other *LinkRecord) bool {
+	return r.OriginalUrl == other.OriginalUrl && r.UserId == other.UserId &&
+		r.Title == other.Title && r.Interstitial == other.Interstitial &&
+		r.RedirectCode() == other.RedirectCode() && r.PasswordHash == other.PasswordHash &&
+		sameTags(r.Tags, other.Tags) && r.Disabled == other.Disabled
+}
+
+func sameTags(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *LinkRecord) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// retainedUntil is the moment an expired record stops answering ErrExpired
+// and is forgotten altogether. Zero means forever.
+func (r *LinkRecord) retainedUntil() time.Time {
+	if r.ExpiresAt.IsZero() {
+		return time.Time{}
+	}
+	return r.ExpiresAt.Add(ExpiredRetention)
+}
+
+// status classifies a stored record at time now: nil while it is live,
+// ErrExpired during the retention window and ErrNotFound afterwards.
+func (r *LinkRecord) status(now time.Time) error {
+	if !r.Expired(now) {
+		return nil
+	}
+	if until := r.retainedUntil(); now.Before(until) {
+		return ErrExpired
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,252 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps every mapping in process memory. It is meant for tests and
+// local development, nothing survives a restart.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	records     map[string]LinkRecord
+	clicks      map[string]*memoryClicks
+	apiKeys     map[string]ApiKey
+	buckets     tokenBuckets
+	idempotency map[string]IdempotencyEntry
+	sequences   map[string]uint64
+	now         func() time.Time
+}
+
+type memoryClicks struct {
+	counters map[string]int64
+	visitors map[string]bool
+	recent   []ClickEvent
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records:     make(map[string]LinkRecord),
+		clicks:      make(map[string]*memoryClicks),
+		apiKeys:     make(map[string]ApiKey),
+		idempotency: make(map[string]IdempotencyEntry),
+		sequences:   make(map[string]uint64),
+		now:         time.Now,
+	}
+}
+
+// lookup must be called with s.mu held.
+func (s *MemoryStore) lookup(shortUrl string) (LinkRecord, error) {
+	record, ok := s.records[shortUrl]
+	if !ok {
+		return LinkRecord{}, ErrNotFound
+	}
+	return record, record.status(s.now())
+}
+
+func (s *MemoryStore) Save(ctx context.Context, record *LinkRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.lookup(record.ShortUrl); err != ErrNotFound {
+		return ErrConflict
+	}
+	record.Version = LinkRecordVersion
+	s.records[record.ShortUrl] = *record
+	return nil
+}
+
+func (s *MemoryStore) SaveBatch(ctx context.Context, records []*LinkRecord) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := make([]error, len(records))
+	for i, record := range records {
+		if _, err := s.lookup(record.ShortUrl); err != ErrNotFound {
+			errs[i] = ErrConflict
+			continue
+		}
+		record.Version = LinkRecordVersion
+		s.records[record.ShortUrl] = *record
+	}
+	return errs
+}
+
+func (s *MemoryStore) Get(ctx context.Context, shortUrl string) (*LinkRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, err := s.lookup(shortUrl)
+	switch err {
+	case nil, ErrExpired:
+		if clicks, ok := s.clicks[shortUrl]; ok {
+			record.Clicks = clicks.counters[counterTotal]
+		}
+		return &record, err
+	default:
+		return nil, err
+	}
+}
+
+func (s *MemoryStore) Update(ctx context.Context, record *LinkRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, err := s.lookup(record.ShortUrl)
+	if err != nil {
+		return err
+	}
+	existing.update(record)
+	s.records[record.ShortUrl] = existing
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, shortUrl string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, shortUrl)
+	delete(s.clicks, shortUrl)
+	return nil
+}
+
+func (s *MemoryStore) Exists(ctx context.Context, shortUrl string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, err := s.lookup(shortUrl)
+	return err == nil, nil
+}
+
+func (s *MemoryStore) ListByUser(ctx context.Context, userId string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	shortUrls := make([]string, 0)
+	for shortUrl := range s.records {
+		if record, err := s.lookup(shortUrl); err == nil && record.UserId == userId {
+			shortUrls = append(shortUrls, shortUrl)
+		}
+	}
+	sort.Strings(shortUrls)
+	return shortUrls, nil
+}
+
+// FindByTarget returns the oldest matching link.
+func (s *MemoryStore) FindByTarget(ctx context.Context, userId string, originalUrl string) (*LinkRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found *LinkRecord
+	for shortUrl := range s.records {
+		record, err := s.lookup(shortUrl)
+		if err != nil || record.UserId != userId || record.OriginalUrl != originalUrl {
+			continue
+		}
+		if found == nil || record.CreatedAt.Before(found.CreatedAt) ||
+			(record.CreatedAt.Equal(found.CreatedAt) && record.ShortUrl < found.ShortUrl) {
+			found = &record
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (s *MemoryStore) NextSequence(ctx context.Context, name string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sequences[name]++
+	return s.sequences[name], nil
+}
+
+func (s *MemoryStore) RecordClick(ctx context.Context, event *ClickEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clicks, ok := s.clicks[event.ShortUrl]
+	if !ok {
+		clicks = &memoryClicks{counters: map[string]int64{}, visitors: map[string]bool{}}
+		s.clicks[event.ShortUrl] = clicks
+	}
+	for _, counter := range event.counters() {
+		clicks.counters[counter]++
+	}
+	if event.IPHash != "" {
+		clicks.visitors[event.IPHash] = true
+	}
+	clicks.recent = prependRecent(clicks.recent, *event)
+	return nil
+}
+
+func (s *MemoryStore) ClickStats(ctx context.Context, shortUrl string) (*ClickStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	clicks, ok := s.clicks[shortUrl]
+	if !ok {
+		return newClickStats(nil, 0, nil), nil
+	}
+	recent := append([]ClickEvent(nil), clicks.recent...)
+	return newClickStats(clicks.counters, int64(len(clicks.visitors)), recent), nil
+}
+
+func (s *MemoryStore) SaveApiKey(ctx context.Context, key *ApiKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.apiKeys[key.Id]; ok {
+		return ErrConflict
+	}
+	s.apiKeys[key.Id] = *key
+	return nil
+}
+
+func (s *MemoryStore) GetApiKey(ctx context.Context, id string) (*ApiKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.apiKeys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &key, nil
+}
+
+func (s *MemoryStore) DeleteApiKey(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.apiKeys[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.apiKeys, id)
+	return nil
+}
+
+func (s *MemoryStore) TakeTokens(ctx context.Context, key string, n int, limit RateLimit) (bool, time.Duration, error) {
+	ok, wait := s.buckets.take(key, n, limit, s.now())
+	return ok, wait, nil
+}
+
+func (s *MemoryStore) SaveIdempotencyKey(ctx context.Context, entry *IdempotencyEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for id, stale := range s.idempotency {
+		if !stale.ExpiresAt.After(now) {
+			delete(s.idempotency, id)
+		}
+	}
+	entry.ExpiresAt = now.Add(IdempotencyKeyTTL)
+	s.idempotency[idempotencyId(entry.UserId, entry.Key)] = *entry
+	return nil
+}
+
+func (s *MemoryStore) GetIdempotencyKey(ctx context.Context, userId string, key string) (*IdempotencyEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.idempotency[idempotencyId(userId, key)]
+	if !ok || !entry.ExpiresAt.After(s.now()) {
+		return nil, ErrNotFound
+	}
+	return &entry, nil
+}
+
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
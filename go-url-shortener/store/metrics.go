@@ -0,0 +1,230 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shortener_store_operation_duration_seconds",
+		Help:    "Latency of the operations of the storage backend.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 9),
+	}, []string{"backend", "operation"})
+	operationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shortener_store_operation_errors_total",
+		Help: "Operations of the storage backend that failed, not counting missing, expired or conflicting links.",
+	}, []string{"backend", "operation"})
+	circuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "shortener_store_circuit_open",
+		Help: "Whether the circuit breaker of the storage backend rejects operations.",
+	}, []string{"backend"})
+)
+
+// activeCache is the cache of the store last set up by InitializeStore, read
+// by cacheCollector.
+var activeCache atomic.Pointer[CachedStore]
+
+// cacheCollector exports the CacheStats of activeCache.
+type cacheCollector struct{}
+
+var (
+	cacheHitsDesc         = prometheus.NewDesc("shortener_cache_hits_total", "Links read from the in-process cache.", nil, nil)
+	cacheNegativeHitsDesc = prometheus.NewDesc("shortener_cache_negative_hits_total", "Cache hits answering that the short url is unknown.", nil, nil)
+	cacheMissesDesc       = prometheus.NewDesc("shortener_cache_misses_total", "Links the cache had to read from the backend.", nil, nil)
+	cacheEvictionsDesc    = prometheus.NewDesc("shortener_cache_evictions_total", "Links dropped from the full cache.", nil, nil)
+	cacheEntriesDesc      = prometheus.NewDesc("shortener_cache_entries", "Links currently cached.", nil, nil)
+)
+
+func init() {
+	prometheus.MustRegister(cacheCollector{})
+}
+
+func (cacheCollector) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{cacheHitsDesc, cacheNegativeHitsDesc, cacheMissesDesc, cacheEvictionsDesc, cacheEntriesDesc} {
+		descs <- desc
+	}
+}
+
+func (cacheCollector) Collect(metrics chan<- prometheus.Metric) {
+	cache := activeCache.Load()
+	if cache == nil {
+		return
+	}
+	stats := cache.Stats()
+	metrics <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(stats.Hits))
+	metrics <- prometheus.MustNewConstMetric(cacheNegativeHitsDesc, prometheus.CounterValue, float64(stats.NegativeHits))
+	metrics <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(stats.Misses))
+	metrics <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(stats.Evictions))
+	metrics <- prometheus.MustNewConstMetric(cacheEntriesDesc, prometheus.GaugeValue, float64(stats.Entries))
+}
+
+// InstrumentedStore records the latency and failures of every operation of
+// the Store it wraps, labelled with the name of its backend.
+type InstrumentedStore struct {
+	backend string
+	store   Store
+}
+
+func NewInstrumentedStore(backend string, s Store) *InstrumentedStore {
+	return &InstrumentedStore{backend: backend, store: s}
+}
+
+// failed tells backend failures apart from the errors reporting the state of
+// a link or key, or that the caller went away.
+func failed(err error) bool {
+	return err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrExpired) && !errors.Is(err, ErrConflict) &&
+		!errors.Is(err, context.Canceled)
+}
+
+// observe also logs the failures, under the request of ctx.
+func (s *InstrumentedStore) observe(ctx context.Context, operation string, start time.Time, errs ...error) {
+	elapsed := time.Since(start)
+	operationDuration.WithLabelValues(s.backend, operation).Observe(elapsed.Seconds())
+	for _, err := range errs {
+		if failed(err) {
+			operationErrors.WithLabelValues(s.backend, operation).Inc()
+			if errors.Is(err, ErrCircuitOpen) {
+				// The breaker logs when it opens.
+				continue
+			}
+			slog.WarnContext(ctx, "store operation failed", "backend", s.backend, "operation", operation, "elapsed", elapsed, "error", err)
+		}
+	}
+}
+
+func (s *InstrumentedStore) Save(ctx context.Context, record *LinkRecord) error {
+	start := time.Now()
+	err := s.store.Save(ctx, record)
+	s.observe(ctx, "save", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) SaveBatch(ctx context.Context, records []*LinkRecord) []error {
+	start := time.Now()
+	errs := s.store.SaveBatch(ctx, records)
+	s.observe(ctx, "save_batch", start, errs...)
+	return errs
+}
+
+func (s *InstrumentedStore) Get(ctx context.Context, shortUrl string) (*LinkRecord, error) {
+	start := time.Now()
+	record, err := s.store.Get(ctx, shortUrl)
+	s.observe(ctx, "get", start, err)
+	return record, err
+}
+
+func (s *InstrumentedStore) Update(ctx context.Context, record *LinkRecord) error {
+	start := time.Now()
+	err := s.store.Update(ctx, record)
+	s.observe(ctx, "update", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) Delete(ctx context.Context, shortUrl string) error {
+	start := time.Now()
+	err := s.store.Delete(ctx, shortUrl)
+	s.observe(ctx, "delete", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) Exists(ctx context.Context, shortUrl string) (bool, error) {
+	start := time.Now()
+	exists, err := s.store.Exists(ctx, shortUrl)
+	s.observe(ctx, "exists", start, err)
+	return exists, err
+}
+
+func (s *InstrumentedStore) ListByUser(ctx context.Context, userId string) ([]string, error) {
+	start := time.Now()
+	codes, err := s.store.ListByUser(ctx, userId)
+	s.observe(ctx, "list_by_user", start, err)
+	return codes, err
+}
+
+func (s *InstrumentedStore) FindByTarget(ctx context.Context, userId string, originalUrl string) (*LinkRecord, error) {
+	start := time.Now()
+	record, err := s.store.FindByTarget(ctx, userId, originalUrl)
+	s.observe(ctx, "find_by_target", start, err)
+	return record, err
+}
+
+func (s *InstrumentedStore) NextSequence(ctx context.Context, name string) (uint64, error) {
+	start := time.Now()
+	next, err := s.store.NextSequence(ctx, name)
+	s.observe(ctx, "next_sequence", start, err)
+	return next, err
+}
+
+func (s *InstrumentedStore) RecordClick(ctx context.Context, event *ClickEvent) error {
+	start := time.Now()
+	err := s.store.RecordClick(ctx, event)
+	s.observe(ctx, "record_click", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) ClickStats(ctx context.Context, shortUrl string) (*ClickStats, error) {
+	start := time.Now()
+	stats, err := s.store.ClickStats(ctx, shortUrl)
+	s.observe(ctx, "click_stats", start, err)
+	return stats, err
+}
+
+func (s *InstrumentedStore) SaveApiKey(ctx context.Context, key *ApiKey) error {
+	start := time.Now()
+	err := s.store.SaveApiKey(ctx, key)
+	s.observe(ctx, "save_api_key", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) GetApiKey(ctx context.Context, id string) (*ApiKey, error) {
+	start := time.Now()
+	apiKey, err := s.store.GetApiKey(ctx, id)
+	s.observe(ctx, "get_api_key", start, err)
+	return apiKey, err
+}
+
+func (s *InstrumentedStore) DeleteApiKey(ctx context.Context, id string) error {
+	start := time.Now()
+	err := s.store.DeleteApiKey(ctx, id)
+	s.observe(ctx, "delete_api_key", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) TakeTokens(ctx context.Context, key string, n int, limit RateLimit) (bool, time.Duration, error) {
+	start := time.Now()
+	allowed, retryAfter, err := s.store.TakeTokens(ctx, key, n, limit)
+	s.observe(ctx, "take_token", start, err)
+	return allowed, retryAfter, err
+}
+
+func (s *InstrumentedStore) SaveIdempotencyKey(ctx context.Context, entry *IdempotencyEntry) error {
+	start := time.Now()
+	err := s.store.SaveIdempotencyKey(ctx, entry)
+	s.observe(ctx, "save_idempotency_key", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) GetIdempotencyKey(ctx context.Context, userId string, key string) (*IdempotencyEntry, error) {
+	start := time.Now()
+	entry, err := s.store.GetIdempotencyKey(ctx, userId, key)
+	s.observe(ctx, "get_idempotency_key", start, err)
+	return entry, err
+}
+
+func (s *InstrumentedStore) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.store.Ping(ctx)
+	s.observe(ctx, "ping", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) Close() error {
+	return s.store.Close()
+}
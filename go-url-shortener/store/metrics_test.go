@@ -0,0 +1,38 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentedStore(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	redisStore, err := NewRedisStore(mr.Addr(), "", 0)
+	require.NoError(t, err)
+	s := NewInstrumentedStore(BackendRedis, redisStore)
+	defer s.Close()
+
+	failures := func(operation string) float64 {
+		return testutil.ToFloat64(operationErrors.WithLabelValues(BackendRedis, operation))
+	}
+	before := failures("get")
+	observed := testutil.CollectAndCount(operationDuration)
+
+	require.NoError(t, s.Save(ctx, &LinkRecord{ShortUrl: "metered", OriginalUrl: "https://example.com", UserId: "owner", CreatedAt: time.Now()}))
+	_, err = s.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, before, failures("get"), "unknown links are not failures")
+	assert.Greater(t, testutil.CollectAndCount(operationDuration), observed)
+
+	mr.Close()
+	_, err = s.Get(ctx, "metered")
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.Equal(t, before+1, failures("get"))
+}
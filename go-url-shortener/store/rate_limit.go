@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket refilled with Rate tokens per second up to
+// Burst tokens. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+func (l RateLimit) Disabled() bool {
+	return l.Rate <= 0
+}
+
+// refillTime is how long an empty bucket takes to fill up, used to expire
+// buckets nobody has touched since.
+func (l RateLimit) refillTime() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// take refills a bucket holding tokens at last and tries to remove n tokens
+// at now. It returns the tokens left, whether they were taken and, when not,
+// how long until there are enough.
+func (l RateLimit) take(tokens float64, last time.Time, now time.Time, n int) (float64, bool, time.Duration) {
+	if last.IsZero() {
+		tokens = float64(l.Burst)
+	} else if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(float64(l.Burst), tokens+elapsed*l.Rate)
+	}
+	if tokens >= float64(n) {
+		return tokens - float64(n), true, 0
+	}
+	wait := time.Duration(math.Ceil((float64(n) - tokens) / l.Rate * float64(time.Second)))
+	return tokens, false, wait
+}
+
+// bucketSweepInterval is how often tokenBuckets looks for idle buckets.
+const bucketSweepInterval = time.Minute
+
+// tokenBuckets keeps the buckets of the single process backends in memory.
+// A bucket left alone for its refill time is full again, no different from a
+// missing one, so such buckets are dropped when the map is next touched.
+type tokenBuckets struct {
+	mu        sync.Mutex
+	buckets   map[string]tokenBucket
+	nextSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	expires time.Time
+}
+
+func (b *tokenBuckets) take(key string, n int, limit RateLimit, now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.buckets == nil {
+		b.buckets = make(map[string]tokenBucket)
+	}
+	if !now.Before(b.nextSweep) {
+		for id, bucket := range b.buckets {
+			if !bucket.expires.After(now) {
+				delete(b.buckets, id)
+			}
+		}
+		b.nextSweep = now.Add(bucketSweepInterval)
+	}
+	bucket := b.buckets[key]
+	tokens, ok, wait := limit.take(bucket.tokens, bucket.last, now, n)
+	b.buckets[key] = tokenBucket{tokens: tokens, last: now, expires: now.Add(limit.refillTime())}
+	return ok, wait
+}
+
+func (b *tokenBuckets) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.buckets)
+}
+
+// TakeTokens takes n tokens from the bucket under key, none of them when it
+// holds fewer, reporting how long to wait until it does. Buckets live in the
+// store so every instance shares them.
+func TakeTokens(ctx context.Context, key string, n int, limit RateLimit) (bool, time.Duration, error) {
+	return storeService.TakeTokens(ctx, key, n, limit)
+}
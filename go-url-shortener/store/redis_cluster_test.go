@@ -0,0 +1,322 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const clusterSlots = 16384
+
+// crc16 is the CRC-16/XMODEM Redis Cluster hashes keys with.
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc ^= uint16(data[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// keySlot is the cluster slot of key, hashing only its hash tag when it has
+// one.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// commandKeys returns the keys of the commands spanning several of them.
+func commandKeys(cmd redis.Cmder) []string {
+	args := make([]string, len(cmd.Args()))
+	for i, arg := range cmd.Args() {
+		args[i] = fmt.Sprint(arg)
+	}
+	switch cmd.Name() {
+	case "eval", "evalsha":
+		var n int
+		fmt.Sscan(args[2], &n)
+		return args[3 : 3+n]
+	case "del", "exists", "unlink", "mget", "touch":
+		return args[1:]
+	default:
+		return nil
+	}
+}
+
+// slotGuard fails the commands a Redis Cluster would refuse with CROSSSLOT,
+// miniredis knowing nothing of slots.
+type slotGuard struct{}
+
+func (slotGuard) check(cmd redis.Cmder) error {
+	keys := commandKeys(cmd)
+	for _, key := range keys {
+		if keySlot(key) != keySlot(keys[0]) {
+			return fmt.Errorf("CROSSSLOT Keys in request don't hash to the same slot: %v", cmd.Args())
+		}
+	}
+	return nil
+}
+
+func (g slotGuard) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, g.check(cmd)
+}
+
+func (slotGuard) AfterProcess(context.Context, redis.Cmder) error {
+	return nil
+}
+
+func (g slotGuard) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		if err := g.check(cmd); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func (slotGuard) AfterProcessPipeline(context.Context, []redis.Cmder) error {
+	return nil
+}
+
+// newTestCluster stands in for a Redis Cluster with miniredis nodes, each
+// serving an equal share of the slots.
+func newTestCluster(t *testing.T, size int) (*RedisStore, []*miniredis.Miniredis) {
+	t.Helper()
+	nodes := make([]*miniredis.Miniredis, size)
+	slots := make([]redis.ClusterSlot, size)
+	for i := range nodes {
+		nodes[i] = miniredis.RunT(t)
+		slots[i] = redis.ClusterSlot{
+			Start: i * clusterSlots / size,
+			End:   (i+1)*clusterSlots/size - 1,
+			Nodes: []redis.ClusterNode{{Addr: nodes[i].Addr()}},
+		}
+	}
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(context.Context) ([]redis.ClusterSlot, error) {
+			return slots, nil
+		},
+	})
+	client.AddHook(slotGuard{})
+	s, err := newRedisStore(client)
+	require.NoError(t, err)
+	return s, nodes
+}
+
+// nodeOf returns the node of the test cluster serving key.
+func nodeOf(nodes []*miniredis.Miniredis, key string) *miniredis.Miniredis {
+	return nodes[keySlot(key)*len(nodes)/clusterSlots]
+}
+
+func TestKeySlot(t *testing.T) {
+	// The reference values of the cluster specification.
+	assert.Equal(t, uint16(0x31c3), crc16("123456789"))
+	assert.Equal(t, keySlot("user1000"), keySlot("{user1000}.following"))
+	assert.Equal(t, keySlot("abc"), keySlot("link:{abc}"))
+}
+
+func TestRedisClusterKeys(t *testing.T) {
+	ctx := context.Background()
+	s, nodes := newTestCluster(t, 3)
+	defer s.Close()
+	assert.True(t, s.keys.cluster)
+	assert.ErrorContains(t, s.redisClient.Del(ctx, "a", "b").Err(), "CROSSSLOT", "the stand-in is as strict as a cluster")
+
+	used := map[*miniredis.Miniredis]bool{}
+	for i := 0; i < 12; i++ {
+		shortUrl := fmt.Sprintf("code%d", i)
+		require.NoError(t, s.Save(ctx, newRecord(shortUrl, "https://example.com/"+shortUrl, "owner")))
+		node := nodeOf(nodes, shortUrl)
+		assert.True(t, node.Exists(s.keys.link(shortUrl)), shortUrl)
+		used[node] = true
+	}
+	assert.Len(t, used, len(nodes), "links are spread over every node")
+
+	owner := nodeOf(nodes, "owner")
+	members, err := owner.Members(s.keys.userLinks("owner"))
+	require.NoError(t, err)
+	assert.Len(t, members, 12)
+	assert.Equal(t, "code3", owner.HGet(s.keys.userTargets("owner"), "https://example.com/code3"))
+
+	listed, err := s.ListByUser(ctx, "owner")
+	require.NoError(t, err)
+	assert.Len(t, listed, 12)
+	found, err := s.FindByTarget(ctx, "owner", "https://example.com/code7")
+	require.NoError(t, err)
+	assert.Equal(t, "code7", found.ShortUrl)
+
+	require.NoError(t, s.RecordClick(ctx, &ClickEvent{ShortUrl: "code1", Timestamp: time.Now(), IPHash: "visitor"}))
+	require.NoError(t, s.Delete(ctx, "code1"))
+	assert.False(t, nodeOf(nodes, "code1").Exists(s.keys.link("code1")))
+	assert.False(t, nodeOf(nodes, "code1").Exists(s.keys.clicks("code1")))
+	members, err = owner.Members(s.keys.userLinks("owner"))
+	require.NoError(t, err)
+	assert.NotContains(t, members, "code1")
+
+	// A legacy mapping shares the slot of the link hash replacing it.
+	bare := nodeOf(nodes, "bare1")
+	require.NoError(t, bare.Set(s.keys.legacy("bare1"), "https://example.com/bare"))
+	record, err := s.Get(ctx, "bare1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/bare", record.OriginalUrl)
+	assert.True(t, bare.Exists(s.keys.link("bare1")))
+}
+
+func TestRedisClusterSaveBatchLoadsScriptEverywhere(t *testing.T) {
+	ctx := context.Background()
+	s, nodes := newTestCluster(t, 3)
+	defer s.Close()
+
+	records := make([]*LinkRecord, 9)
+	for i := range records {
+		shortUrl := fmt.Sprintf("batch%d", i)
+		records[i] = newRecord(shortUrl, "https://example.com/"+shortUrl, "owner")
+	}
+	for i, err := range s.SaveBatch(ctx, records) {
+		assert.NoError(t, err, records[i].ShortUrl)
+	}
+	for _, record := range records {
+		assert.True(t, nodeOf(nodes, record.ShortUrl).Exists(s.keys.link(record.ShortUrl)))
+	}
+	listed, err := s.ListByUser(ctx, "owner")
+	require.NoError(t, err)
+	assert.Len(t, listed, len(records))
+}
+
+// fakeSentinel answers the few Sentinel commands clients rely on for a single
+// master, and announces failovers to its subscribers.
+type fakeSentinel struct {
+	srv  *server.Server
+	name string
+
+	mu          sync.Mutex
+	master      string
+	subscribers []*server.Peer
+}
+
+func newFakeSentinel(t *testing.T, name string, master string) *fakeSentinel {
+	t.Helper()
+	srv, err := server.NewServer("127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(srv.Close)
+	sentinel := &fakeSentinel{srv: srv, name: name, master: master}
+
+	require.NoError(t, srv.Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		switch {
+		case len(args) == 2 && strings.EqualFold(args[0], "get-master-addr-by-name") && args[1] == name:
+			sentinel.mu.Lock()
+			host, port, _ := net.SplitHostPort(sentinel.master)
+			sentinel.mu.Unlock()
+			c.WriteStrings([]string{host, port})
+		case len(args) == 2 && strings.EqualFold(args[0], "get-master-addr-by-name"):
+			c.WriteNull()
+		case len(args) == 2 && strings.EqualFold(args[0], "sentinels"):
+			c.WriteLen(0)
+		default:
+			c.WriteError("ERR unsupported SENTINEL subcommand")
+		}
+	}))
+	require.NoError(t, srv.Register("SUBSCRIBE", func(c *server.Peer, cmd string, args []string) {
+		sentinel.mu.Lock()
+		sentinel.subscribers = append(sentinel.subscribers, c)
+		sentinel.mu.Unlock()
+		c.Block(func(w *server.Writer) {
+			for i, channel := range args {
+				w.WriteLen(3)
+				w.WriteBulk("subscribe")
+				w.WriteBulk(channel)
+				w.WriteInt(i + 1)
+			}
+		})
+	}))
+	return sentinel
+}
+
+func (s *fakeSentinel) Addr() string {
+	return s.srv.Addr().String()
+}
+
+// failover promotes master and publishes the switch the way Sentinel does.
+func (s *fakeSentinel) failover(master string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldHost, oldPort, _ := net.SplitHostPort(s.master)
+	newHost, newPort, _ := net.SplitHostPort(master)
+	s.master = master
+	payload := strings.Join([]string{s.name, oldHost, oldPort, newHost, newPort}, " ")
+	for _, peer := range s.subscribers {
+		peer.Block(func(w *server.Writer) {
+			w.WriteLen(3)
+			w.WriteBulk("message")
+			w.WriteBulk("+switch-master")
+			w.WriteBulk(payload)
+			w.Flush()
+		})
+	}
+}
+
+func TestRedisSentinelFailover(t *testing.T) {
+	ctx := context.Background()
+	primary := miniredis.RunT(t)
+	replica := miniredis.RunT(t)
+	sentinel := newFakeSentinel(t, "mymaster", primary.Addr())
+
+	s, err := NewUniversalRedisStore(&redis.UniversalOptions{
+		Addrs:      []string{sentinel.Addr()},
+		MasterName: "mymaster",
+	}, false)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.False(t, s.keys.cluster)
+
+	require.NoError(t, s.Save(ctx, newRecord("before1", "https://example.com/before", "owner")))
+	assert.True(t, primary.Exists(s.keys.link("before1")))
+
+	// miniredis does not replicate, so the promoted replica starts empty.
+	sentinel.failover(replica.Addr())
+	primary.Close()
+	require.Eventually(t, func() bool {
+		return s.Save(ctx, newRecord("after1", "https://example.com/after", "owner")) == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.True(t, replica.Exists(s.keys.link("after1")))
+	record, err := s.Get(ctx, "after1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/after", record.OriginalUrl)
+}
+
+func TestUniversalRedisStoreForcedCluster(t *testing.T) {
+	// A single seed only means a cluster when asked to; miniredis answers
+	// CLUSTER SLOTS as a one node cluster.
+	mr := miniredis.RunT(t)
+	s, err := NewUniversalRedisStore(&redis.UniversalOptions{Addrs: []string{mr.Addr()}}, true)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.True(t, s.keys.cluster)
+	require.NoError(t, s.Save(context.Background(), newRecord("seed1", "https://example.com/seed", "owner")))
+	assert.True(t, mr.Exists("link:{seed1}"))
+
+	single, err := NewUniversalRedisStore(&redis.UniversalOptions{Addrs: []string{mr.Addr()}}, false)
+	require.NoError(t, err)
+	defer single.Close()
+	assert.False(t, single.keys.cluster)
+}
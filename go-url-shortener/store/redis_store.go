@@ -0,0 +1,849 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisStore keeps every LinkRecord in a "link:" hash that outlives the link
+// by ExpiredRetention, an owner -> short urls set for listing and an owner's
+// original url -> short url hash for deduplication. Links stored by earlier
+// releases as a plain short url -> original url string, with or without a
+// "meta:" hash, are upgraded when first read. Sequences, API keys,
+// idempotency entries and rate limit buckets live under systemPrefix. It runs against a single
+// server, a Sentinel monitored master or a Redis Cluster.
+type RedisStore struct {
+	redisClient redis.UniversalClient
+	keys        keyspace
+	now         func() time.Time
+}
+
+func NewRedisStore(addr string, password string, db int) (*RedisStore, error) {
+	return NewUniversalRedisStore(&redis.UniversalOptions{
+		Addrs:    []string{addr},
+		Password: password,
+		DB:       db,
+	}, false)
+}
+
+// NewUniversalRedisStore connects to the Sentinel monitored master when
+// opts.MasterName is set, to a Redis Cluster when opts lists several addresses
+// or cluster is set, and to a single server otherwise.
+func NewUniversalRedisStore(opts *redis.UniversalOptions, cluster bool) (*RedisStore, error) {
+	var redisClient redis.UniversalClient
+	if cluster && opts.MasterName == "" {
+		redisClient = redis.NewClusterClient(opts.Cluster())
+	} else {
+		redisClient = redis.NewUniversalClient(opts)
+	}
+	s, err := newRedisStore(redisClient)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("connected to redis", "addrs", opts.Addrs, "master", opts.MasterName, "cluster", s.keys.cluster, "db", opts.DB)
+	return s, nil
+}
+
+func newRedisStore(redisClient redis.UniversalClient) (*RedisStore, error) {
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		redisClient.Close()
+		return nil, fmt.Errorf("init redis: %w", err)
+	}
+	_, cluster := redisClient.(*redis.ClusterClient)
+	return &RedisStore{redisClient: redisClient, keys: keyspace{cluster: cluster}, now: time.Now}, nil
+}
+
+// keyspace names the keys of the links and of their owners. In a cluster the
+// keys of a link share the hash tag of its short url and those of an owner
+// the hash tag of the owner, so that the scripts and transactions spanning
+// them run on a single node. Elsewhere the names are those of earlier
+// releases, so links saved there are not found once the store is moved to a
+// cluster without renaming its keys.
+type keyspace struct {
+	cluster bool
+}
+
+func (k keyspace) tag(id string) string {
+	if k.cluster {
+		return "{" + id + "}"
+	}
+	return id
+}
+
+func (k keyspace) link(shortUrl string) string {
+	return "link:" + k.tag(shortUrl)
+}
+
+// legacy is the plain string of the legacy layout, which hashes to the same
+// slot as the hash tag of shortUrl.
+func (k keyspace) legacy(shortUrl string) string {
+	return shortUrl
+}
+
+// meta is the metadata hash of the legacy layout.
+func (k keyspace) meta(shortUrl string) string {
+	return "meta:" + k.tag(shortUrl)
+}
+
+func (k keyspace) userLinks(userId string) string {
+	return "user:" + k.tag(userId) + ":links"
+}
+
+func (k keyspace) userTargets(userId string) string {
+	return "user:" + k.tag(userId) + ":targets"
+}
+
+func (k keyspace) clicks(shortUrl string) string {
+	return "clicks:" + k.tag(shortUrl)
+}
+
+func (k keyspace) clickVisitors(shortUrl string) string {
+	return "clicks:" + k.tag(shortUrl) + ":visitors"
+}
+
+func (k keyspace) recentClicks(shortUrl string) string {
+	return "clicks:" + k.tag(shortUrl) + ":recent"
+}
+
+// systemPrefix starts the keys of the store's own state. Its ':' is outside
+// the charset of codes, so no link lookup can address them.
+const systemPrefix = "_sys:"
+
+func sequenceKey(name string) string {
+	return systemPrefix + "seq:" + name
+}
+
+func apiKeyKey(id string) string {
+	return systemPrefix + "apikey:" + id
+}
+
+func idempotencyKey(userId string, key string) string {
+	return systemPrefix + "idempotency:" + userId + ":" + key
+}
+
+func rateLimitKey(key string) string {
+	return systemPrefix + "ratelimit:" + key
+}
+
+// recordTime is the fixed width UTC layout of the times in a link hash, so
+// that scripts can compare them as strings.
+const recordTime = "2006-01-02T15:04:05.000000000Z"
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(recordTime)
+}
+
+func parseTime(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, value)
+	return t
+}
+
+// mutableFields are the link hash fields Update rewrites, as name/value
+// pairs.
+func mutableFields(record *LinkRecord) []interface{} {
+	flag := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return ""
+	}
+	redirectStatus := ""
+	if record.RedirectStatus != 0 {
+		redirectStatus = strconv.Itoa(record.RedirectStatus)
+	}
+	tags := ""
+	if len(record.Tags) > 0 {
+		data, _ := json.Marshal(record.Tags)
+		tags = string(data)
+	}
+	return []interface{}{
+		"url", record.OriginalUrl,
+		"expires_at", formatTime(record.ExpiresAt),
+		"title", record.Title,
+		"interstitial", flag(record.Interstitial),
+		"redirect_status", redirectStatus,
+		"password_hash", record.PasswordHash,
+		"tags", tags,
+		"disabled", flag(record.Disabled),
+	}
+}
+
+// recordFields are all the fields of the link hash of record.
+func recordFields(record *LinkRecord) []interface{} {
+	fields := []interface{}{
+		"version", LinkRecordVersion,
+		"user_id", record.UserId,
+		"created_at", formatTime(record.CreatedAt),
+	}
+	return append(fields, mutableFields(record)...)
+}
+
+func recordFromFields(shortUrl string, fields map[string]string) *LinkRecord {
+	record := &LinkRecord{
+		ShortUrl:     shortUrl,
+		OriginalUrl:  fields["url"],
+		UserId:       fields["user_id"],
+		CreatedAt:    parseTime(fields["created_at"]),
+		ExpiresAt:    parseTime(fields["expires_at"]),
+		Title:        fields["title"],
+		Interstitial: fields["interstitial"] == "1",
+		PasswordHash: fields["password_hash"],
+		Disabled:     fields["disabled"] == "1",
+	}
+	record.Version, _ = strconv.Atoi(fields["version"])
+	record.RedirectStatus, _ = strconv.Atoi(fields["redirect_status"])
+	if tags := fields["tags"]; tags != "" {
+		_ = json.Unmarshal([]byte(tags), &record.Tags)
+	}
+	return record
+}
+
+// ttlUntil converts a deadline into a positive millisecond TTL.
+func (s *RedisStore) ttlUntil(deadline time.Time) int64 {
+	ttl := deadline.Sub(s.now()).Milliseconds()
+	if ttl < 1 {
+		ttl = 1
+	}
+	return ttl
+}
+
+// retentionTTL is the TTL of the link hash of record, 0 when it is kept
+// forever.
+func (s *RedisStore) retentionTTL(record *LinkRecord) int64 {
+	if record.ExpiresAt.IsZero() {
+		return 0
+	}
+	return s.ttlUntil(record.retainedUntil())
+}
+
+// saveScript claims the link hash KEYS[1] only when neither it nor a legacy
+// mapping (KEYS[2] and KEYS[3]) exist, all of them in the slot of the short
+// url. ARGV[1] is the TTL of the hash, 0 meaning none, and ARGV[2] onwards
+// its fields as name/value pairs.
+var saveScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1], KEYS[2], KEYS[3]) > 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], unpack(ARGV, 2))
+if tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// saveArgs returns the keys and arguments of saveScript for record.
+func (s *RedisStore) saveArgs(record *LinkRecord) ([]string, []interface{}) {
+	keys := []string{s.keys.link(record.ShortUrl), s.keys.legacy(record.ShortUrl), s.keys.meta(record.ShortUrl)}
+	args := []interface{}{s.retentionTTL(record)}
+	return keys, append(args, recordFields(record)...)
+}
+
+// indexOwner adds record to the owner indexes, which live in the slot of the
+// owner rather than that of the link and so are written once it is claimed.
+// The target index keeps the first link saved for a url, links being saved
+// as they are created. ListByUser and FindByTarget already cope with entries
+// of links gone since.
+func (s *RedisStore) indexOwner(ctx context.Context, pipe redis.Pipeliner, record *LinkRecord) []redis.Cmder {
+	return []redis.Cmder{
+		pipe.SAdd(ctx, s.keys.userLinks(record.UserId), record.ShortUrl),
+		pipe.HSetNX(ctx, s.keys.userTargets(record.UserId), record.OriginalUrl, record.ShortUrl),
+	}
+}
+
+func (s *RedisStore) Save(ctx context.Context, record *LinkRecord) error {
+	keys, args := s.saveArgs(record)
+	created, err := saveScript.Run(ctx, s.redisClient, keys, args...).Int()
+	if err != nil {
+		return unavailable(err)
+	}
+	if created == 0 {
+		return ErrConflict
+	}
+	_, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		s.indexOwner(ctx, pipe, record)
+		return nil
+	})
+	if err != nil {
+		return unavailable(err)
+	}
+	record.Version = LinkRecordVersion
+	return nil
+}
+
+// SaveBatch runs saveScript for every record in one pipeline, loading the
+// script first when the server does not know it yet, then indexes the
+// records created in a second one.
+func (s *RedisStore) SaveBatch(ctx context.Context, records []*LinkRecord) []error {
+	errs := make([]error, len(records))
+	if len(records) == 0 {
+		return errs
+	}
+	run := func() ([]*redis.Cmd, error) {
+		pipe := s.redisClient.Pipeline()
+		cmds := make([]*redis.Cmd, len(records))
+		for i, record := range records {
+			keys, args := s.saveArgs(record)
+			cmds[i] = saveScript.EvalSha(ctx, pipe, keys, args...)
+		}
+		_, err := pipe.Exec(ctx)
+		return cmds, err
+	}
+
+	cmds, err := run()
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		if err := saveScript.Load(ctx, s.redisClient).Err(); err != nil {
+			for i := range errs {
+				errs[i] = unavailable(err)
+			}
+			return errs
+		}
+		cmds, _ = run()
+	}
+	var created []int
+	for i, cmd := range cmds {
+		claimed, err := cmd.Int()
+		switch {
+		case err != nil:
+			errs[i] = unavailable(err)
+		case claimed == 0:
+			errs[i] = ErrConflict
+		default:
+			created = append(created, i)
+		}
+	}
+	if len(created) == 0 {
+		return errs
+	}
+
+	// Equally old links are indexed smallest code first, the one FindByTarget
+	// prefers.
+	sort.Slice(created, func(a, b int) bool {
+		return records[created[a]].ShortUrl < records[created[b]].ShortUrl
+	})
+	pipe := s.redisClient.Pipeline()
+	indexed := make([][]redis.Cmder, len(created))
+	for j, i := range created {
+		indexed[j] = s.indexOwner(ctx, pipe, records[i])
+	}
+	_, _ = pipe.Exec(ctx)
+	for j, i := range created {
+		for _, cmd := range indexed[j] {
+			if err := cmd.Err(); err != nil {
+				errs[i] = unavailable(err)
+			}
+		}
+		if errs[i] == nil {
+			records[i].Version = LinkRecordVersion
+		}
+	}
+	return errs
+}
+
+func (s *RedisStore) Get(ctx context.Context, shortUrl string) (*LinkRecord, error) {
+	pipe := s.redisClient.Pipeline()
+	fields := pipe.HGetAll(ctx, s.keys.link(shortUrl))
+	clicks := pipe.HGet(ctx, s.keys.clicks(shortUrl), counterTotal)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, unavailable(err)
+	}
+	if len(fields.Val()) == 0 {
+		return s.getLegacy(ctx, shortUrl)
+	}
+
+	record := recordFromFields(shortUrl, fields.Val())
+	record.Clicks, _ = clicks.Int64()
+	switch err := record.status(s.now()); err {
+	case nil, ErrExpired:
+		return record, err
+	default:
+		return nil, err
+	}
+}
+
+// upgradeScript replaces the legacy mapping KEYS[2] and its metadata KEYS[3]
+// with the link hash KEYS[1], unless another client got there first. ARGV[1]
+// is the TTL of the hash, 0 meaning none, and ARGV[2] onwards its fields.
+var upgradeScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("HSET", KEYS[1], unpack(ARGV, 2))
+if tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+redis.call("DEL", KEYS[2], KEYS[3])
+return 1
+`)
+
+// legacyCodePattern is the charset of every code handed out by earlier
+// releases. It has no ':', so a legacy key never names one of the store's
+// own keys.
+var legacyCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// isLegacyTarget tells the original url of a legacy mapping from the other
+// strings sharing its keyspace.
+func isLegacyTarget(value string) bool {
+	target, err := url.Parse(value)
+	if err != nil || target.Host == "" {
+		return false
+	}
+	scheme := strings.ToLower(target.Scheme)
+	return scheme == "http" || scheme == "https"
+}
+
+// getLegacy reads shortUrl from the layout preceding the link hash: the
+// original url as a plain string expiring with the link, possibly alongside
+// a "meta:" hash with the rest of the record, and upgrades it. Only strings
+// holding an http(s) url under a key that could be a code are taken for
+// legacy mappings.
+func (s *RedisStore) getLegacy(ctx context.Context, shortUrl string) (*LinkRecord, error) {
+	if !legacyCodePattern.MatchString(shortUrl) {
+		return nil, ErrNotFound
+	}
+	pipe := s.redisClient.Pipeline()
+	kind := pipe.Type(ctx, s.keys.legacy(shortUrl))
+	urlTTL := pipe.PTTL(ctx, s.keys.legacy(shortUrl))
+	meta := pipe.HGetAll(ctx, s.keys.meta(shortUrl))
+	clicks := pipe.HGet(ctx, s.keys.clicks(shortUrl), counterTotal)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, unavailable(err)
+	}
+	originalUrl := ""
+	switch kind.Val() {
+	case "none":
+	case "string":
+		value, err := s.redisClient.Get(ctx, s.keys.legacy(shortUrl)).Result()
+		if err != nil && err != redis.Nil {
+			return nil, unavailable(err)
+		}
+		if err == nil && !isLegacyTarget(value) {
+			return nil, ErrNotFound
+		}
+		originalUrl = value
+	default:
+		return nil, ErrNotFound
+	}
+	fields := meta.Val()
+	if originalUrl == "" && len(fields) == 0 {
+		return nil, ErrNotFound
+	}
+	if fields == nil {
+		fields = map[string]string{}
+	}
+
+	fields["url"] = originalUrl
+	record := recordFromFields(shortUrl, fields)
+	record.Version = 1
+	if record.ExpiresAt.IsZero() && urlTTL.Val() > 0 {
+		// Bare strings only know their expiry from their TTL.
+		record.ExpiresAt = s.now().Add(urlTTL.Val())
+	}
+	status := record.status(s.now())
+	if originalUrl == "" && status != ErrExpired {
+		// Only the metadata of an expired link was retained, and no longer
+		// should be.
+		return nil, ErrNotFound
+	}
+
+	keys := []string{s.keys.link(shortUrl), s.keys.legacy(shortUrl), s.keys.meta(shortUrl)}
+	args := append([]interface{}{s.retentionTTL(record)}, recordFields(record)...)
+	upgraded, err := upgradeScript.Run(ctx, s.redisClient, keys, args...).Int()
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	if upgraded == 0 {
+		return s.Get(ctx, shortUrl)
+	}
+	slog.DebugContext(ctx, "upgraded legacy link", "code", shortUrl, "version", LinkRecordVersion)
+	record.Version = LinkRecordVersion
+	record.Clicks, _ = clicks.Int64()
+	return record, status
+}
+
+// updateScript rewrites the mutable fields (ARGV[3] onwards) of the link hash
+// KEYS[1] while it is live at ARGV[1] and moves its TTL along, 0 meaning
+// none.
+var updateScript = redis.NewScript(`
+local expires = redis.call("HGET", KEYS[1], "expires_at")
+if not expires or (expires ~= "" and expires <= ARGV[1]) then
+	return 0
+end
+redis.call("HSET", KEYS[1], unpack(ARGV, 3))
+if tonumber(ARGV[2]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+else
+	redis.call("PERSIST", KEYS[1])
+end
+return 1
+`)
+
+// Update blanks the target index entry of the url record now points to, as
+// record may be older than the link it names: FindByTarget rebuilds it.
+func (s *RedisStore) Update(ctx context.Context, record *LinkRecord) error {
+	run := func() (int, error) {
+		args := append([]interface{}{formatTime(s.now()), s.retentionTTL(record)}, mutableFields(record)...)
+		return updateScript.Run(ctx, s.redisClient, []string{s.keys.link(record.ShortUrl)}, args...).Int()
+	}
+	updated, err := run()
+	if err != nil {
+		return unavailable(err)
+	}
+	if updated == 1 {
+		s.blankTarget(ctx, record)
+		return nil
+	}
+	// Gone, expired or still in the legacy layout, which Get upgrades.
+	if _, err := s.Get(ctx, record.ShortUrl); err != nil {
+		return err
+	}
+	if updated, err = run(); err != nil {
+		return unavailable(err)
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	s.blankTarget(ctx, record)
+	return nil
+}
+
+func (s *RedisStore) blankTarget(ctx context.Context, record *LinkRecord) {
+	s.redisClient.HSet(ctx, s.keys.userTargets(record.UserId), record.OriginalUrl, "")
+}
+
+// Delete drops the keys of the link and its entry in the owner's set in one
+// transaction, which a cluster splits between their two slots.
+func (s *RedisStore) Delete(ctx context.Context, shortUrl string) error {
+	pipe := s.redisClient.Pipeline()
+	owner := pipe.HGet(ctx, s.keys.link(shortUrl), "user_id")
+	legacyOwner := pipe.HGet(ctx, s.keys.meta(shortUrl), "user_id")
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return unavailable(err)
+	}
+	userId := owner.Val()
+	if userId == "" {
+		userId = legacyOwner.Val()
+	}
+	keys := []string{s.keys.link(shortUrl), s.keys.meta(shortUrl), s.keys.clicks(shortUrl), s.keys.clickVisitors(shortUrl), s.keys.recentClicks(shortUrl)}
+	if legacyCodePattern.MatchString(shortUrl) {
+		keys = append(keys, s.keys.legacy(shortUrl))
+	}
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keys...)
+		if userId != "" {
+			pipe.SRem(ctx, s.keys.userLinks(userId), shortUrl)
+		}
+		return nil
+	})
+	return unavailable(err)
+}
+
+func (s *RedisStore) Exists(ctx context.Context, shortUrl string) (bool, error) {
+	_, err := s.Get(ctx, shortUrl)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrExpired):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// ListByUser returns the live short urls of userId, pruning the ones that
+// are gone (or have been reclaimed by someone else) since they were indexed.
+func (s *RedisStore) ListByUser(ctx context.Context, userId string) ([]string, error) {
+	shortUrls, err := s.redisClient.SMembers(ctx, s.keys.userLinks(userId)).Result()
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	if len(shortUrls) == 0 {
+		return shortUrls, nil
+	}
+
+	pipe := s.redisClient.Pipeline()
+	owners := make([]*redis.SliceCmd, len(shortUrls))
+	for i, shortUrl := range shortUrls {
+		owners[i] = pipe.HMGet(ctx, s.keys.link(shortUrl), "user_id", "expires_at")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, unavailable(err)
+	}
+
+	now := s.now()
+	live := make([]string, 0, len(shortUrls))
+	for i, shortUrl := range shortUrls {
+		var record *LinkRecord
+		if values := owners[i].Val(); values[0] != nil {
+			owner, _ := values[0].(string)
+			expiresAt, _ := values[1].(string)
+			record = &LinkRecord{UserId: owner, ExpiresAt: parseTime(expiresAt)}
+		} else {
+			// Not upgraded yet, or gone.
+			record, err = s.getLegacy(ctx, shortUrl)
+			if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrExpired) {
+				return nil, err
+			}
+		}
+		switch {
+		case record == nil || record.UserId != userId:
+			s.redisClient.SRem(ctx, s.keys.userLinks(userId), shortUrl)
+		case !record.Expired(now):
+			live = append(live, shortUrl)
+		}
+	}
+	sort.Strings(live)
+	return live, nil
+}
+
+// FindByTarget follows the owner's target index, which names the oldest
+// link saved for a url but is not updated when links go away: when its entry
+// is stale or blanked by Update, the live links of the owner are scanned and
+// the entry rebuilt.
+func (s *RedisStore) FindByTarget(ctx context.Context, userId string, originalUrl string) (*LinkRecord, error) {
+	shortUrl, err := s.redisClient.HGet(ctx, s.keys.userTargets(userId), originalUrl).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	if shortUrl != "" {
+		record, err := s.Get(ctx, shortUrl)
+		if err == nil && record.UserId == userId && record.OriginalUrl == originalUrl {
+			return record, nil
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrExpired) {
+			return nil, err
+		}
+	}
+
+	shortUrl, err = s.oldestTarget(ctx, userId, originalUrl)
+	if err != nil {
+		return nil, err
+	}
+	if shortUrl == "" {
+		s.redisClient.HDel(ctx, s.keys.userTargets(userId), originalUrl)
+		return nil, ErrNotFound
+	}
+	s.redisClient.HSet(ctx, s.keys.userTargets(userId), originalUrl, shortUrl)
+	record, err := s.Get(ctx, shortUrl)
+	if errors.Is(err, ErrExpired) {
+		return nil, ErrNotFound
+	}
+	return record, err
+}
+
+// oldestTarget scans the live links of userId for the oldest one to
+// originalUrl, ties going to the smallest code. It returns "" when there is
+// none.
+func (s *RedisStore) oldestTarget(ctx context.Context, userId string, originalUrl string) (string, error) {
+	shortUrls, err := s.ListByUser(ctx, userId)
+	if err != nil || len(shortUrls) == 0 {
+		return "", err
+	}
+	pipe := s.redisClient.Pipeline()
+	fields := make([]*redis.SliceCmd, len(shortUrls))
+	for i, shortUrl := range shortUrls {
+		fields[i] = pipe.HMGet(ctx, s.keys.link(shortUrl), "url", "created_at")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return "", unavailable(err)
+	}
+
+	oldest := ""
+	var oldestAt time.Time
+	// ListByUser sorts the codes, so the first of equally old links stays.
+	for i, shortUrl := range shortUrls {
+		values := fields[i].Val()
+		if url, _ := values[0].(string); url != originalUrl {
+			continue
+		}
+		createdAt, _ := values[1].(string)
+		if at := parseTime(createdAt); oldest == "" || at.Before(oldestAt) {
+			oldest, oldestAt = shortUrl, at
+		}
+	}
+	return oldest, nil
+}
+
+func (s *RedisStore) NextSequence(ctx context.Context, name string) (uint64, error) {
+	value, err := s.redisClient.Incr(ctx, sequenceKey(name)).Uint64()
+	return value, unavailable(err)
+}
+
+// RecordClick bumps the click counters in a hash, tracks unique visitors in a
+// HyperLogLog and keeps the latest raw events in a capped list.
+func (s *RedisStore) RecordClick(ctx context.Context, event *ClickEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, counter := range event.counters() {
+			pipe.HIncrBy(ctx, s.keys.clicks(event.ShortUrl), counter, 1)
+		}
+		if event.IPHash != "" {
+			pipe.PFAdd(ctx, s.keys.clickVisitors(event.ShortUrl), event.IPHash)
+		}
+		pipe.LPush(ctx, s.keys.recentClicks(event.ShortUrl), data)
+		pipe.LTrim(ctx, s.keys.recentClicks(event.ShortUrl), 0, MaxRecentClicks-1)
+		return nil
+	})
+	return unavailable(err)
+}
+
+func (s *RedisStore) ClickStats(ctx context.Context, shortUrl string) (*ClickStats, error) {
+	pipe := s.redisClient.Pipeline()
+	counters := pipe.HGetAll(ctx, s.keys.clicks(shortUrl))
+	visitors := pipe.PFCount(ctx, s.keys.clickVisitors(shortUrl))
+	recentData := pipe.LRange(ctx, s.keys.recentClicks(shortUrl), 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, unavailable(err)
+	}
+
+	values := make(map[string]int64, len(counters.Val()))
+	for name, value := range counters.Val() {
+		values[name], _ = strconv.ParseInt(value, 10, 64)
+	}
+	recent := make([]ClickEvent, 0, len(recentData.Val()))
+	for _, data := range recentData.Val() {
+		var event ClickEvent
+		if err := json.Unmarshal([]byte(data), &event); err == nil {
+			recent = append(recent, event)
+		}
+	}
+	return newClickStats(values, visitors.Val(), recent), nil
+}
+
+func (s *RedisStore) SaveApiKey(ctx context.Context, key *ApiKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	created, err := s.redisClient.SetNX(ctx, apiKeyKey(key.Id), data, 0).Result()
+	if err != nil {
+		return unavailable(err)
+	}
+	if !created {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *RedisStore) GetApiKey(ctx context.Context, id string) (*ApiKey, error) {
+	data, err := s.redisClient.Get(ctx, apiKeyKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	key := &ApiKey{}
+	if err := json.Unmarshal(data, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *RedisStore) DeleteApiKey(ctx context.Context, id string) error {
+	deleted, err := s.redisClient.Del(ctx, apiKeyKey(id)).Result()
+	if err != nil {
+		return unavailable(err)
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// takeTokenScript is RateLimit.take over a "tokens"/"last" hash. ARGV holds
+// the rate per second, the burst, the current unix time in milliseconds, the
+// TTL of the bucket in milliseconds and the tokens to take. It returns 1 or 0
+// followed by the wait in milliseconds.
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(bucket[1])
+local last = tonumber(bucket[2])
+if tokens == nil or last == nil then
+	tokens = burst
+elseif now > last then
+	tokens = math.min(burst, tokens + (now - last) / 1000 * rate)
+end
+local n = tonumber(ARGV[5])
+local allowed, wait = 0, 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	wait = math.ceil((n - tokens) / rate * 1000)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last", now)
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+return {allowed, wait}
+`)
+
+func (s *RedisStore) TakeTokens(ctx context.Context, key string, n int, limit RateLimit) (bool, time.Duration, error) {
+	ttl := limit.refillTime().Milliseconds() + 1000
+	result, err := takeTokenScript.Run(ctx, s.redisClient, []string{rateLimitKey(key)},
+		limit.Rate, limit.Burst, s.now().UnixMilli(), ttl, n,
+	).Result()
+	if err != nil {
+		return false, 0, unavailable(err)
+	}
+	reply, _ := result.([]interface{})
+	if len(reply) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit reply %v", result)
+	}
+	allowed, _ := reply[0].(int64)
+	wait, _ := reply[1].(int64)
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
+
+func (s *RedisStore) SaveIdempotencyKey(ctx context.Context, entry *IdempotencyEntry) error {
+	entry.ExpiresAt = s.now().Add(IdempotencyKeyTTL)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return unavailable(s.redisClient.Set(ctx, idempotencyKey(entry.UserId, entry.Key), data, IdempotencyKeyTTL).Err())
+}
+
+func (s *RedisStore) GetIdempotencyKey(ctx context.Context, userId string, key string) (*IdempotencyEntry, error) {
+	data, err := s.redisClient.Get(ctx, idempotencyKey(userId, key)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	entry := &IdempotencyEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return unavailable(s.redisClient.Ping(ctx).Err())
+}
+
+func (s *RedisStore) Close() error {
+	return s.redisClient.Close()
+}
@@ -2,50 +2,277 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-type StorageService struct {
-	redisClient *redis.Client
+// Store is implemented by every storage backend the shortener can run on.
+// Every operation runs under the context of the request it serves, whose
+// request id the backends log with.
+type Store interface {
+	// Save only creates: it fails with ErrConflict, atomically, when the
+	// short url is already taken, including by an expired link that is still
+	// retained, so an existing mapping is never overwritten.
+	Save(ctx context.Context, record *LinkRecord) error
+	// SaveBatch is Save for many records at once, returning one error per
+	// record.
+	SaveBatch(ctx context.Context, records []*LinkRecord) []error
+	// Get reports ErrExpired, together with the retained record, for an
+	// expired link and ErrNotFound once it is gone.
+	Get(ctx context.Context, shortUrl string) (*LinkRecord, error)
+	// Update replaces the target, expiry, title, interstitial flag, redirect
+	// status, password hash, tags and disabled flag of a live link.
+	Update(ctx context.Context, record *LinkRecord) error
+	// Delete also drops the click statistics of the short url.
+	Delete(ctx context.Context, shortUrl string) error
+	// Exists reports whether shortUrl is a live link.
+	Exists(ctx context.Context, shortUrl string) (bool, error)
+	// ListByUser returns the short urls of the live links of userId, sorted.
+	ListByUser(ctx context.Context, userId string) ([]string, error)
+	// FindByTarget returns the oldest live link of userId to originalUrl, the
+	// smallest short url among equally old ones, or ErrNotFound.
+	FindByTarget(ctx context.Context, userId string, originalUrl string) (*LinkRecord, error)
+	// NextSequence returns the next value of an atomic counter starting at 1.
+	NextSequence(ctx context.Context, name string) (uint64, error)
+	// RecordClick adds event to the statistics of its short url.
+	RecordClick(ctx context.Context, event *ClickEvent) error
+	// ClickStats returns empty statistics for a short url never clicked.
+	ClickStats(ctx context.Context, shortUrl string) (*ClickStats, error)
+	// SaveApiKey fails with ErrConflict on a duplicate id.
+	SaveApiKey(ctx context.Context, key *ApiKey) error
+	// GetApiKey fails with ErrNotFound on an unknown or deleted id.
+	GetApiKey(ctx context.Context, id string) (*ApiKey, error)
+	// DeleteApiKey fails with ErrNotFound on an unknown id.
+	DeleteApiKey(ctx context.Context, id string) error
+	// TakeTokens takes n tokens from the bucket under key, none of them when
+	// it holds fewer, reporting how long to wait until it does.
+	TakeTokens(ctx context.Context, key string, n int, limit RateLimit) (bool, time.Duration, error)
+	// SaveIdempotencyKey remembers entry for IdempotencyKeyTTL.
+	SaveIdempotencyKey(ctx context.Context, entry *IdempotencyEntry) error
+	// GetIdempotencyKey fails with ErrNotFound once the entry has expired.
+	GetIdempotencyKey(ctx context.Context, userId string, key string) (*IdempotencyEntry, error)
+	// Ping reports whether the backend can be reached.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+	BackendBolt   = "bolt"
+)
+
+// Options selects and configures the backend created by InitializeStore.
+type Options struct {
+	Backend string `yaml:"backend"`
+
+	// RedisAddr lists the server, the Sentinels when RedisMasterName is set or
+	// the cluster seeds, comma separated. Several addresses without a master
+	// name, or RedisCluster, select a Redis Cluster.
+	RedisAddr             string `yaml:"redis_addr"`
+	RedisUsername         string `yaml:"redis_username"`
+	RedisPassword         string `yaml:"redis_password"`
+	RedisDB               int    `yaml:"redis_db"`
+	RedisMasterName       string `yaml:"redis_master_name"`
+	RedisSentinelPassword string `yaml:"redis_sentinel_password"`
+	RedisCluster          bool   `yaml:"redis_cluster"`
+
+	BoltPath string `yaml:"bolt_path"`
+
+	// CacheSize links are kept in a CachedStore in front of the backend, none
+	// when zero.
+	CacheSize        int           `yaml:"cache_size"`
+	CacheTTL         time.Duration `yaml:"cache_ttl"`
+	CacheNegativeTTL time.Duration `yaml:"cache_negative_ttl"`
+
+	// ReadTimeout and WriteTimeout bound every read and write of the backend,
+	// none being set when zero. BreakerThreshold consecutive failures of the
+	// backend fail operations fast for BreakerCooldown; zero disables it.
+	ReadTimeout      time.Duration `yaml:"read_timeout"`
+	WriteTimeout     time.Duration `yaml:"write_timeout"`
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Backend:   BackendRedis,
+		RedisAddr: "127.0.0.1:6379",
+		BoltPath:  "shortener.db",
+
+		CacheSize:        10000,
+		CacheTTL:         10 * time.Second,
+		CacheNegativeTTL: 2 * time.Second,
+
+		ReadTimeout:      500 * time.Millisecond,
+		WriteTimeout:     2 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  5 * time.Second,
+	}
 }
 
 var (
-	storeService = &StorageService{}
-	ctx          = context.Background()
+	ErrNotFound           = errors.New("short url not found")
+	ErrConflict           = errors.New("short url already mapped to another url")
+	ErrExpired            = errors.New("short url expired")
+	ErrBackendUnavailable = errors.New("storage backend unavailable")
 )
 
-const CacheDuration = 6 * time.Hour
+// unavailable tags a backend failure with ErrBackendUnavailable so callers can
+// tell it apart from a missing or conflicting mapping.
+func unavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+}
 
-func InitializeStore() *StorageService {
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     "127.0.0.1:6379",
-		Password: "",
-		DB:       0,
-	})
+var storeService Store
 
-	pong, err := redisClient.Ping(ctx).Result()
-	if err != nil {
-		panic(fmt.Sprintf("Error init Redis: %v", err))
+var (
+	// DefaultTTL applies to links created without an explicit expiry. Zero
+	// makes them never expire.
+	DefaultTTL = 6 * time.Hour
+	// ExpiredRetention is how long the metadata of an expired link is kept so
+	// it can be told apart from a link that never existed.
+	ExpiredRetention = 30 * 24 * time.Hour
+)
+
+// RedisAddrs splits RedisAddr.
+func (opts Options) RedisAddrs() []string {
+	var addrs []string
+	for _, addr := range strings.Split(opts.RedisAddr, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
 	}
-	fmt.Printf("\nRedis started successfully: pong message = {%s}", pong)
-	storeService.redisClient = redisClient
-	return storeService
+	return addrs
 }
 
-func SaveUrlMapping(shortUrl string, originalUrl string, userId string) {
-	err := storeService.redisClient.Set(ctx, shortUrl, originalUrl, CacheDuration).Err()
+func (opts Options) redisOptions() *redis.UniversalOptions {
+	return &redis.UniversalOptions{
+		Addrs:            opts.RedisAddrs(),
+		Username:         opts.RedisUsername,
+		Password:         opts.RedisPassword,
+		DB:               opts.RedisDB,
+		MasterName:       opts.RedisMasterName,
+		SentinelPassword: opts.RedisSentinelPassword,
+	}
+}
+
+func InitializeStore(opts Options) (Store, error) {
+	var (
+		s   Store
+		err error
+	)
+	switch opts.Backend {
+	case BackendRedis:
+		s, err = NewUniversalRedisStore(opts.redisOptions(), opts.RedisCluster)
+	case BackendMemory:
+		s = NewMemoryStore()
+	case BackendBolt:
+		s, err = NewBoltStore(opts.BoltPath)
+	default:
+		err = fmt.Errorf("unknown store backend %q", opts.Backend)
+	}
 	if err != nil {
-		panic(fmt.Sprintf("Failed saving key url | Error: %v - shortUrl: %s - originalUrl: %s\n", err, shortUrl, originalUrl))
+		return nil, err
 	}
+	var breaker *Breaker
+	if opts.BreakerThreshold > 0 {
+		breaker = NewBreaker(opts.BreakerThreshold, opts.BreakerCooldown)
+	}
+	s = NewGuardedStore(opts.Backend, s, opts.ReadTimeout, opts.WriteTimeout, breaker)
+	s = NewInstrumentedStore(opts.Backend, s)
+	activeCache.Store(nil)
+	if opts.CacheSize > 0 {
+		cache := NewCachedStore(s, opts.CacheSize, opts.CacheTTL, opts.CacheNegativeTTL)
+		activeCache.Store(cache)
+		s = cache
+	}
+	storeService = s
+	return s, nil
 }
 
-func RetrieveInitialUrl(shortUrl string) string {
-	result, err := storeService.redisClient.Get(ctx, shortUrl).Result()
+// SaveUrlMapping stores record, refusing with ErrConflict when its short url is
+// already mapped to a different url or user, or presented differently. Saving
+// a mapping that already exists as an Equivalent of record is a no-op.
+func SaveUrlMapping(ctx context.Context, record *LinkRecord) error {
+	_, err := SaveOrReuseUrlMapping(ctx, record)
+	return err
+}
+
+// SaveOrReuseUrlMapping is SaveUrlMapping reporting whether record was newly
+// created. When it is reused, record is overwritten with the stored mapping so
+// its original metadata is kept.
+func SaveOrReuseUrlMapping(ctx context.Context, record *LinkRecord) (bool, error) {
+	err := storeService.Save(ctx, record)
+	if !errors.Is(err, ErrConflict) {
+		return err == nil, err
+	}
+	existing, getErr := storeService.Get(ctx, record.ShortUrl)
+	if getErr == nil && existing.Equivalent(record) {
+		*record = *existing
+		return false, nil
+	}
+	return false, err
+}
+
+// CreateUrlMapping is the strict form of SaveUrlMapping: any existing mapping
+// of the short url, even to the same url, is reported as ErrConflict.
+func CreateUrlMapping(ctx context.Context, record *LinkRecord) error {
+	return storeService.Save(ctx, record)
+}
+
+// CreateUrlMappings saves records like CreateUrlMapping, in as few round trips
+// as the backend allows. The error of records[i] is errs[i].
+func CreateUrlMappings(ctx context.Context, records []*LinkRecord) []error {
+	return storeService.SaveBatch(ctx, records)
+}
+
+func RetrieveLink(ctx context.Context, shortUrl string) (*LinkRecord, error) {
+	return storeService.Get(ctx, shortUrl)
+}
+
+func UpdateUrlMapping(ctx context.Context, record *LinkRecord) error {
+	return storeService.Update(ctx, record)
+}
+
+func DeleteUrlMapping(ctx context.Context, shortUrl string) error {
+	return storeService.Delete(ctx, shortUrl)
+}
+
+func ListUserUrls(ctx context.Context, userId string) ([]string, error) {
+	return storeService.ListByUser(ctx, userId)
+}
+
+// FindUserLink returns a live link userId already has to originalUrl.
+func FindUserLink(ctx context.Context, userId string, originalUrl string) (*LinkRecord, error) {
+	return storeService.FindByTarget(ctx, userId, originalUrl)
+}
+
+// NextSequenceValue increments the counter name shared by every instance.
+func NextSequenceValue(ctx context.Context, name string) (uint64, error) {
+	return storeService.NextSequence(ctx, name)
+}
+
+func RetrieveInitialUrl(ctx context.Context, shortUrl string) (string, error) {
+	record, err := storeService.Get(ctx, shortUrl)
 	if err != nil {
-		panic(fmt.Sprintf("Failed RetrieveInitialUrl url | Error: %v - shortUrl: %s\n", err, shortUrl))
+		return "", err
 	}
-	return result
+	return record.OriginalUrl, nil
+}
+
+func RetrieveClickStats(ctx context.Context, shortUrl string) (*ClickStats, error) {
+	return storeService.ClickStats(ctx, shortUrl)
+}
+
+// PingBackend reports whether the storage backend can be reached.
+func PingBackend(ctx context.Context) error {
+	return storeService.Ping(ctx)
 }
@@ -1,31 +1,748 @@
 package store
 
 import (
+	"context"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-var testStoreService = &StorageService{}
+var testStoreService Store
 
 func init() {
-	testStoreService = InitializeStore()
+	testStoreService, _ = InitializeStore(Options{Backend: BackendMemory})
+}
+
+// testBackend is a Store under test together with a way to move its clock.
+type testBackend struct {
+	Store
+	advance func(d time.Duration)
+}
+
+// fakeClock replaces time.Now in the backends under test.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// backends opens a fresh instance of every backend, Redis being served by an
+// in-process miniredis, or three of them standing in for a cluster, so no
+// external service is needed. Each of them runs on
+// its own fake clock that advance moves forward.
+func backends(t *testing.T) map[string]testBackend {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	redisStore, err := NewRedisStore(mr.Addr(), "", 0)
+	require.NoError(t, err)
+	redisClock := &fakeClock{now: time.Now()}
+	redisStore.now = redisClock.Now
+
+	boltStore, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	boltClock := &fakeClock{now: time.Now()}
+	boltStore.now = boltClock.Now
+
+	clusterStore, clusterNodes := newTestCluster(t, 3)
+	clusterClock := &fakeClock{now: time.Now()}
+	clusterStore.now = clusterClock.Now
+
+	memoryStore := NewMemoryStore()
+	memoryClock := &fakeClock{now: time.Now()}
+	memoryStore.now = memoryClock.Now
+
+	stores := map[string]testBackend{
+		BackendRedis: {redisStore, func(d time.Duration) {
+			redisClock.now = redisClock.now.Add(d)
+			mr.FastForward(d)
+		}},
+		"redis-cluster": {clusterStore, func(d time.Duration) {
+			clusterClock.now = clusterClock.now.Add(d)
+			for _, node := range clusterNodes {
+				node.FastForward(d)
+			}
+		}},
+		BackendMemory: {memoryStore, func(d time.Duration) {
+			memoryClock.now = memoryClock.now.Add(d)
+		}},
+		BackendBolt: {boltStore, func(d time.Duration) {
+			boltClock.now = boltClock.now.Add(d)
+		}},
+	}
+	t.Cleanup(func() {
+		for _, s := range stores {
+			s.Close()
+		}
+	})
+	return stores
+}
+
+func newRecord(shortUrl string, originalUrl string, userId string) *LinkRecord {
+	return &LinkRecord{
+		ShortUrl:    shortUrl,
+		OriginalUrl: originalUrl,
+		UserId:      userId,
+		CreatedAt:   time.Now(),
+	}
 }
 
 func TestStoreInit(t *testing.T) {
-	assert.True(t, testStoreService.redisClient != nil)
+	assert.True(t, testStoreService != nil)
+	assert.NoError(t, testStoreService.Ping(context.Background()))
+}
+
+func TestInitializeStoreUnknownBackend(t *testing.T) {
+	_, err := InitializeStore(Options{Backend: "cassandra"})
+	assert.Error(t, err)
 }
 
 func TestInsertionAndRetrieval(t *testing.T) {
+	ctx := context.Background()
 	initialLink := "https://www.guru3d.com/news-story/spotted-ryzen-threadripper-pro-3995wx-processor-with-8-channel-ddr4,2.html"
 	userUUId := "e0dba740-fc4b-4977-872c-d360239e6b1a"
 	shortURL := "Jsz4k57oAX"
 
 	// Persist data mapping
-	SaveUrlMapping(shortURL, initialLink, userUUId)
+	require.NoError(t, SaveUrlMapping(ctx, newRecord(shortURL, initialLink, userUUId)))
 
 	// Retrieve initial URL
-	retrievedUrl := RetrieveInitialUrl(shortURL)
+	retrievedUrl, err := RetrieveInitialUrl(ctx, shortURL)
+	require.NoError(t, err)
 
 	assert.Equal(t, initialLink, retrievedUrl)
 }
+
+func TestSaveUrlMappingConflict(t *testing.T) {
+	ctx := context.Background()
+	userUUId := "e0dba740-fc4b-4977-872c-d360239e6b1a"
+	shortURL := "conflict1"
+
+	require.NoError(t, SaveUrlMapping(ctx, newRecord(shortURL, "https://example.com/a", userUUId)))
+	// Saving the same mapping again is allowed.
+	require.NoError(t, SaveUrlMapping(ctx, newRecord(shortURL, "https://example.com/a", userUUId)))
+
+	err := SaveUrlMapping(ctx, newRecord(shortURL, "https://example.com/b", userUUId))
+	assert.ErrorIs(t, err, ErrConflict)
+	err = SaveUrlMapping(ctx, newRecord(shortURL, "https://example.com/a", "another-user"))
+	assert.ErrorIs(t, err, ErrConflict)
+
+	retrievedUrl, err := RetrieveInitialUrl(ctx, shortURL)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/a", retrievedUrl)
+}
+
+func TestSaveOrReuseUrlMapping(t *testing.T) {
+	ctx := context.Background()
+	first := newRecord("reuse1", "https://example.com/a", "owner")
+	first.CreatedAt = time.Now().Add(-time.Hour).UTC()
+	created, err := SaveOrReuseUrlMapping(ctx, first)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	again := newRecord("reuse1", "https://example.com/a", "owner")
+	created, err = SaveOrReuseUrlMapping(ctx, again)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, first.CreatedAt, again.CreatedAt, "the original metadata is kept")
+
+	interstitial := newRecord("reuse1", "https://example.com/a", "owner")
+	interstitial.Interstitial = true
+	_, err = SaveOrReuseUrlMapping(ctx, interstitial)
+	assert.ErrorIs(t, err, ErrConflict, "a link presented differently is not reused")
+}
+
+func TestRetrieveInitialUrlNotFound(t *testing.T) {
+	_, err := RetrieveInitialUrl(context.Background(), "does-not-exist")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRedisBackendUnavailable(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	s, err := NewRedisStore(mr.Addr(), "", 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	mr.Close()
+
+	_, err = s.Get(ctx, "anything")
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.ErrorIs(t, s.Save(ctx, newRecord("anything", "https://example.com", "user")), ErrBackendUnavailable)
+}
+
+func TestBackends(t *testing.T) {
+	ctx := context.Background()
+	const userId = "e0dba740-fc4b-4977-872c-d360239e6b1a"
+
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, s.Save(ctx, newRecord("b1", "https://example.com/1", userId)))
+			require.NoError(t, s.Save(ctx, newRecord("a2", "https://example.com/2", userId)))
+			require.NoError(t, s.Save(ctx, newRecord("c3", "https://example.com/3", "someone-else")))
+
+			assert.ErrorIs(t, s.Save(ctx, newRecord("b1", "https://example.com/other", "someone-else")), ErrConflict)
+
+			got, err := s.Get(ctx, "b1")
+			require.NoError(t, err)
+			assert.Equal(t, "https://example.com/1", got.OriginalUrl)
+			assert.Equal(t, userId, got.UserId)
+			assert.True(t, got.ExpiresAt.IsZero())
+
+			_, err = s.Get(ctx, "missing")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			ok, err := s.Exists(ctx, "a2")
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			links, err := s.ListByUser(ctx, userId)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a2", "b1"}, links)
+
+			require.NoError(t, s.Delete(ctx, "b1"))
+			ok, err = s.Exists(ctx, "b1")
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			links, err = s.ListByUser(ctx, userId)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a2"}, links)
+
+			links, err = s.ListByUser(ctx, "nobody")
+			require.NoError(t, err)
+			assert.Empty(t, links)
+		})
+	}
+}
+
+func TestBackendsExpiry(t *testing.T) {
+	ctx := context.Background()
+	const userId = "e0dba740-fc4b-4977-872c-d360239e6b1a"
+
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			record := newRecord("temp1", "https://example.com/temp", userId)
+			record.ExpiresAt = time.Now().Add(time.Hour)
+			require.NoError(t, s.Save(ctx, record))
+
+			got, err := s.Get(ctx, "temp1")
+			require.NoError(t, err)
+			assert.WithinDuration(t, record.ExpiresAt, got.ExpiresAt, time.Millisecond)
+
+			s.advance(2 * time.Hour)
+
+			retained, err := s.Get(ctx, "temp1")
+			assert.ErrorIs(t, err, ErrExpired)
+			require.NotNil(t, retained)
+			assert.Equal(t, userId, retained.UserId)
+			assert.ErrorIs(t, s.Update(ctx, record), ErrExpired)
+			ok, err := s.Exists(ctx, "temp1")
+			require.NoError(t, err)
+			assert.False(t, ok)
+			links, err := s.ListByUser(ctx, userId)
+			require.NoError(t, err)
+			assert.Empty(t, links)
+
+			// A retained code cannot be reclaimed yet.
+			assert.ErrorIs(t, s.Save(ctx, newRecord("temp1", "https://example.com/new", "someone-else")), ErrConflict)
+
+			s.advance(ExpiredRetention)
+
+			_, err = s.Get(ctx, "temp1")
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.NoError(t, s.Save(ctx, newRecord("temp1", "https://example.com/new", "someone-else")))
+		})
+	}
+}
+
+func TestBackendsClicks(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, s.Save(ctx, newRecord("clk1", "https://example.com/clicks", "owner")))
+
+			events := []ClickEvent{
+				{ShortUrl: "clk1", Timestamp: day, Referrer: "news.example.org", Country: "DE", IPHash: "aa"},
+				{ShortUrl: "clk1", Timestamp: day.Add(10 * time.Minute), Country: "DE", IPHash: "aa"},
+				{ShortUrl: "clk1", Timestamp: day.Add(2 * time.Hour), Referrer: "news.example.org", Country: "FR", IPHash: "bb"},
+			}
+			for i := range events {
+				require.NoError(t, s.RecordClick(ctx, &events[i]))
+			}
+
+			stats, err := s.ClickStats(ctx, "clk1")
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), stats.Total)
+			assert.Equal(t, int64(2), stats.UniqueVisitors)
+			assert.Equal(t, map[string]int64{"2024-03-01T10": 2, "2024-03-01T12": 1}, stats.Hourly)
+			assert.Equal(t, map[string]int64{"2024-03-01": 3}, stats.Daily)
+			assert.Equal(t, map[string]int64{"DE": 2, "FR": 1}, stats.Countries)
+			assert.Equal(t, map[string]int64{"news.example.org": 2}, stats.Referrers)
+			require.Len(t, stats.Recent, 3)
+			assert.Equal(t, "FR", stats.Recent[0].Country)
+
+			empty, err := s.ClickStats(ctx, "nothing")
+			require.NoError(t, err)
+			assert.Zero(t, empty.Total)
+			assert.Empty(t, empty.Recent)
+
+			require.NoError(t, s.Delete(ctx, "clk1"))
+			stats, err = s.ClickStats(ctx, "clk1")
+			require.NoError(t, err)
+			assert.Zero(t, stats.Total)
+		})
+	}
+}
+
+func TestBackendsUpdate(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			record := newRecord("upd1", "https://example.com/before", "owner")
+			record.ExpiresAt = time.Now().Add(time.Hour)
+			require.NoError(t, s.Save(ctx, record))
+
+			record.OriginalUrl = "https://example.com/after"
+			record.ExpiresAt = time.Time{}
+			require.NoError(t, s.Update(ctx, record))
+
+			got, err := s.Get(ctx, "upd1")
+			require.NoError(t, err)
+			assert.Equal(t, "https://example.com/after", got.OriginalUrl)
+			assert.Equal(t, "owner", got.UserId)
+			assert.True(t, got.ExpiresAt.IsZero())
+
+			// The link no longer expires.
+			s.advance(2 * time.Hour)
+			_, err = s.Get(ctx, "upd1")
+			require.NoError(t, err)
+
+			record.ExpiresAt = time.Now().Add(3 * time.Hour)
+			require.NoError(t, s.Update(ctx, record))
+			s.advance(2 * time.Hour)
+			_, err = s.Get(ctx, "upd1")
+			assert.ErrorIs(t, err, ErrExpired)
+
+			assert.ErrorIs(t, s.Update(ctx, newRecord("missing", "https://example.com", "owner")), ErrNotFound)
+		})
+	}
+}
+
+func TestBackendsPresentation(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			record := newRecord("pres1", "https://example.com", "owner")
+			record.Title = "Quarterly report"
+			record.Interstitial = true
+			record.RedirectStatus = 308
+			record.PasswordHash = "$2a$04$hash"
+			require.NoError(t, s.Save(ctx, record))
+
+			got, err := s.Get(ctx, "pres1")
+			require.NoError(t, err)
+			assert.Equal(t, "Quarterly report", got.Title)
+			assert.True(t, got.Interstitial)
+			assert.Equal(t, 308, got.RedirectCode())
+			assert.Equal(t, "$2a$04$hash", got.PasswordHash)
+
+			record.Title = ""
+			record.Interstitial = false
+			record.RedirectStatus = 0
+			record.PasswordHash = ""
+			require.NoError(t, s.Update(ctx, record))
+			got, err = s.Get(ctx, "pres1")
+			require.NoError(t, err)
+			assert.Empty(t, got.Title)
+			assert.False(t, got.Interstitial)
+			assert.Equal(t, DefaultRedirectStatus, got.RedirectCode())
+			assert.Empty(t, got.PasswordHash)
+		})
+	}
+}
+
+func TestBackendsRecordModel(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			record := newRecord("model1", "https://example.com", "owner")
+			record.Tags = []string{"launch", "email"}
+			require.NoError(t, s.Save(ctx, record))
+			assert.Equal(t, LinkRecordVersion, record.Version)
+
+			for i := 0; i < 2; i++ {
+				require.NoError(t, s.RecordClick(ctx, &ClickEvent{ShortUrl: "model1", Timestamp: time.Now()}))
+			}
+			got, err := s.Get(ctx, "model1")
+			require.NoError(t, err)
+			assert.Equal(t, LinkRecordVersion, got.Version)
+			assert.Equal(t, []string{"launch", "email"}, got.Tags)
+			assert.False(t, got.Disabled)
+			assert.Equal(t, int64(2), got.Clicks)
+
+			record.Tags = nil
+			record.Disabled = true
+			require.NoError(t, s.Update(ctx, record))
+			got, err = s.Get(ctx, "model1")
+			require.NoError(t, err)
+			assert.Empty(t, got.Tags)
+			assert.True(t, got.Disabled)
+		})
+	}
+}
+
+func TestRedisLegacyRecords(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	s, err := NewRedisStore(mr.Addr(), "", 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// A bare string, as stored by the first releases.
+	require.NoError(t, mr.Set("bare1", "https://example.com/bare"))
+	mr.SetTTL("bare1", time.Hour)
+	record, err := s.Get(ctx, "bare1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/bare", record.OriginalUrl)
+	assert.Equal(t, LinkRecordVersion, record.Version)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), record.ExpiresAt, 5*time.Second)
+	assert.False(t, mr.Exists("bare1"), "the legacy key is replaced")
+	assert.True(t, mr.Exists(s.keys.link("bare1")))
+	again, err := s.Get(ctx, "bare1")
+	require.NoError(t, err)
+	assert.Equal(t, record.OriginalUrl, again.OriginalUrl)
+	assert.True(t, record.ExpiresAt.Equal(again.ExpiresAt))
+
+	require.NoError(t, mr.Set("bare2", "https://example.com/forever"))
+	record, err = s.Get(ctx, "bare2")
+	require.NoError(t, err)
+	assert.True(t, record.ExpiresAt.IsZero())
+
+	// A string with its metadata hash, listed by its owner.
+	createdAt := time.Now().Add(-time.Hour).UTC()
+	require.NoError(t, mr.Set("meta1", "https://example.com/meta"))
+	mr.HSet(s.keys.meta("meta1"), "user_id", "owner", "created_at", createdAt.Format(time.RFC3339Nano), "expires_at", "", "title", "Old")
+	_, err = mr.SAdd(s.keys.userLinks("owner"), "meta1")
+	require.NoError(t, err)
+	listed, err := s.ListByUser(ctx, "owner")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"meta1"}, listed)
+	record, err = s.Get(ctx, "meta1")
+	require.NoError(t, err)
+	assert.Equal(t, "owner", record.UserId)
+	assert.Equal(t, "Old", record.Title)
+	assert.True(t, createdAt.Equal(record.CreatedAt))
+	assert.False(t, mr.Exists(s.keys.meta("meta1")))
+
+	// The metadata retained for an expired link.
+	mr.HSet(s.keys.meta("gone1"), "user_id", "owner", "expires_at", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano))
+	_, err = s.Get(ctx, "gone1")
+	assert.ErrorIs(t, err, ErrExpired)
+
+	// Legacy codes are neither reclaimed nor lost to updates and deletes.
+	require.NoError(t, mr.Set("bare3", "https://example.com/taken"))
+	assert.ErrorIs(t, s.Save(ctx, newRecord("bare3", "https://example.com/other", "someone")), ErrConflict)
+	require.NoError(t, mr.Set("bare4", "https://example.com/before"))
+	require.NoError(t, s.Update(ctx, newRecord("bare4", "https://example.com/after", "")))
+	record, err = s.Get(ctx, "bare4")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/after", record.OriginalUrl)
+	require.NoError(t, mr.Set("bare5", "https://example.com/deleted"))
+	require.NoError(t, s.Delete(ctx, "bare5"))
+	_, err = s.Get(ctx, "bare5")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRedisLegacyLookupLeavesStoreKeys(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	s, err := NewRedisStore(mr.Addr(), "", 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := s.NextSequence(ctx, "short_codes")
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.SaveApiKey(ctx, &ApiKey{Id: "k1", Hash: "deadbeef", UserId: "owner"}))
+	require.NoError(t, mr.Set("counter1", "5"))
+	mr.HSet("hash1", "url", "https://example.com/hash")
+
+	for _, key := range []string{sequenceKey("short_codes"), apiKeyKey("k1"), "counter1", "hash1"} {
+		_, err := s.Get(ctx, key)
+		assert.ErrorIs(t, err, ErrNotFound, key)
+		assert.True(t, mr.Exists(key), key)
+		assert.False(t, mr.Exists(s.keys.link(key)), key)
+	}
+	require.NoError(t, s.Delete(ctx, apiKeyKey("k1")))
+	assert.True(t, mr.Exists(apiKeyKey("k1")))
+
+	value, err := s.NextSequence(ctx, "short_codes")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), value)
+	key, err := s.GetApiKey(ctx, "k1")
+	require.NoError(t, err)
+	assert.Equal(t, "deadbeef", key.Hash)
+}
+
+func TestRedisStoreKeysUnreachableAsCodes(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	s, err := NewRedisStore(mr.Addr(), "", 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.NextSequence(ctx, "short_codes")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveApiKey(ctx, &ApiKey{Id: "k1", Hash: "deadbeef", UserId: "owner"}))
+	require.NoError(t, s.SaveIdempotencyKey(ctx, &IdempotencyEntry{UserId: "owner", Key: "retry-1", ShortUrl: "abc123"}))
+	_, _, err = s.TakeTokens(ctx, "client", 1, RateLimit{Rate: 1, Burst: 5})
+	require.NoError(t, err)
+
+	keys := mr.Keys()
+	require.Len(t, keys, 4)
+	for _, key := range keys {
+		assert.True(t, strings.HasPrefix(key, systemPrefix), key)
+		_, err := s.Get(ctx, key)
+		assert.ErrorIs(t, err, ErrNotFound, key)
+	}
+	assert.Equal(t, keys, mr.Keys(), "lookups left the keys alone")
+}
+
+func TestBackendsApiKeys(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			key := &ApiKey{Id: "k1", Hash: "deadbeef", UserId: "owner", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+			require.NoError(t, s.SaveApiKey(ctx, key))
+			assert.ErrorIs(t, s.SaveApiKey(ctx, key), ErrConflict)
+
+			got, err := s.GetApiKey(ctx, "k1")
+			require.NoError(t, err)
+			assert.Equal(t, key, got)
+
+			require.NoError(t, s.DeleteApiKey(ctx, "k1"))
+			_, err = s.GetApiKey(ctx, "k1")
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.ErrorIs(t, s.DeleteApiKey(ctx, "k1"), ErrNotFound)
+		})
+	}
+}
+
+func TestBackendsTakeTokens(t *testing.T) {
+	ctx := context.Background()
+	limit := RateLimit{Rate: 2, Burst: 3}
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < limit.Burst; i++ {
+				ok, _, err := s.TakeTokens(ctx, "client", 1, limit)
+				require.NoError(t, err)
+				assert.True(t, ok, "token %d", i)
+			}
+			ok, wait, err := s.TakeTokens(ctx, "client", 1, limit)
+			require.NoError(t, err)
+			assert.False(t, ok)
+			assert.Equal(t, 500*time.Millisecond, wait)
+
+			// Buckets are independent of each other.
+			ok, _, err = s.TakeTokens(ctx, "other", 1, limit)
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			s.advance(time.Second)
+			for i := 0; i < 2; i++ {
+				ok, _, err = s.TakeTokens(ctx, "client", 1, limit)
+				require.NoError(t, err)
+				assert.True(t, ok)
+			}
+			ok, _, err = s.TakeTokens(ctx, "client", 1, limit)
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			// Refilling stops at the burst.
+			s.advance(time.Hour)
+			for i := 0; i < limit.Burst; i++ {
+				ok, _, _ = s.TakeTokens(ctx, "client", 1, limit)
+				assert.True(t, ok)
+			}
+			ok, _, _ = s.TakeTokens(ctx, "client", 1, limit)
+			assert.False(t, ok)
+
+			// Several tokens are taken at once or not at all.
+			s.advance(time.Hour)
+			ok, _, err = s.TakeTokens(ctx, "client", limit.Burst, limit)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			s.advance(500 * time.Millisecond)
+			ok, wait, err = s.TakeTokens(ctx, "client", 2, limit)
+			require.NoError(t, err)
+			assert.False(t, ok)
+			assert.Equal(t, 500*time.Millisecond, wait)
+			ok, _, err = s.TakeTokens(ctx, "client", 1, limit)
+			require.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestTokenBucketsDropIdle(t *testing.T) {
+	limit := RateLimit{Rate: 1, Burst: 90}
+	var buckets tokenBuckets
+	now := time.Now()
+	buckets.take("idle", 1, limit, now)
+	assert.Equal(t, 1, buckets.len())
+
+	// Still refilling when the next sweep comes.
+	now = now.Add(bucketSweepInterval)
+	buckets.take("busy", 1, limit, now)
+	assert.Equal(t, 2, buckets.len())
+
+	// The first one is full again and goes.
+	now = now.Add(bucketSweepInterval)
+	ok, _ := buckets.take("busy", 1, limit, now)
+	assert.True(t, ok)
+	assert.Equal(t, 1, buckets.len())
+}
+
+func TestBackendsIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := s.GetIdempotencyKey(ctx, "owner", "retry-1")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, s.SaveIdempotencyKey(ctx, &IdempotencyEntry{UserId: "owner", Key: "retry-1", Fingerprint: "f1", ShortUrl: "abc"}))
+			got, err := s.GetIdempotencyKey(ctx, "owner", "retry-1")
+			require.NoError(t, err)
+			assert.Equal(t, "abc", got.ShortUrl)
+			assert.Equal(t, "f1", got.Fingerprint)
+
+			// Keys are scoped per user.
+			_, err = s.GetIdempotencyKey(ctx, "someone-else", "retry-1")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			s.advance(IdempotencyKeyTTL + time.Second)
+			_, err = s.GetIdempotencyKey(ctx, "owner", "retry-1")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestBackendsFindByTarget(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, s.Save(ctx, newRecord("find1", "https://example.com/a", "owner")))
+			require.NoError(t, s.Save(ctx, newRecord("find2", "https://example.com/a", "someone-else")))
+
+			found, err := s.FindByTarget(ctx, "owner", "https://example.com/a")
+			require.NoError(t, err)
+			assert.Equal(t, "find1", found.ShortUrl)
+
+			_, err = s.FindByTarget(ctx, "owner", "https://example.com/b")
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = s.FindByTarget(ctx, "nobody", "https://example.com/a")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			// The oldest link wins, whatever its code.
+			newer := newRecord("find0", "https://example.com/a", "owner")
+			newer.CreatedAt = time.Now().Add(time.Hour)
+			require.NoError(t, s.Save(ctx, newer))
+			found, err = s.FindByTarget(ctx, "owner", "https://example.com/a")
+			require.NoError(t, err)
+			assert.Equal(t, "find1", found.ShortUrl)
+
+			// Including one moved to the url since.
+			older := newRecord("find4", "https://example.com/d", "owner")
+			older.CreatedAt = time.Now().Add(-time.Hour)
+			require.NoError(t, s.Save(ctx, older))
+			older.OriginalUrl = "https://example.com/a"
+			require.NoError(t, s.Update(ctx, older))
+			found, err = s.FindByTarget(ctx, "owner", "https://example.com/a")
+			require.NoError(t, err)
+			assert.Equal(t, "find4", found.ShortUrl)
+			_, err = s.FindByTarget(ctx, "owner", "https://example.com/d")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			// Deleted and expired links are not found any more.
+			require.NoError(t, s.Delete(ctx, "find4"))
+			require.NoError(t, s.Delete(ctx, "find1"))
+			found, err = s.FindByTarget(ctx, "owner", "https://example.com/a")
+			require.NoError(t, err)
+			assert.Equal(t, "find0", found.ShortUrl)
+			require.NoError(t, s.Delete(ctx, "find0"))
+			_, err = s.FindByTarget(ctx, "owner", "https://example.com/a")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			expiring := newRecord("find3", "https://example.com/c", "owner")
+			expiring.ExpiresAt = time.Now().Add(time.Minute)
+			require.NoError(t, s.Save(ctx, expiring))
+			s.advance(2 * time.Minute)
+			_, err = s.FindByTarget(ctx, "owner", "https://example.com/c")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestBackendsNextSequence(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			for want := uint64(1); want <= 3; want++ {
+				value, err := s.NextSequence(ctx, "codes")
+				require.NoError(t, err)
+				assert.Equal(t, want, value)
+			}
+			value, err := s.NextSequence(ctx, "other")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), value)
+		})
+	}
+}
+
+func TestBackendsSaveBatch(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			// Batching first makes the redis backend load its script.
+			errs := s.SaveBatch(ctx, []*LinkRecord{
+				newRecord("batch1", "https://example.com/1", "owner"),
+				newRecord("batch2", "https://example.com/2", "owner"),
+				newRecord("batch1", "https://example.com/3", "owner"),
+			})
+			require.Len(t, errs, 3)
+			assert.NoError(t, errs[0])
+			assert.NoError(t, errs[1])
+			assert.ErrorIs(t, errs[2], ErrConflict)
+
+			errs = s.SaveBatch(ctx, []*LinkRecord{
+				newRecord("batch2", "https://example.com/other", "owner"),
+				newRecord("batch3", "https://example.com/3", "owner"),
+			})
+			assert.ErrorIs(t, errs[0], ErrConflict)
+			assert.NoError(t, errs[1])
+
+			got, err := s.Get(ctx, "batch1")
+			require.NoError(t, err)
+			assert.Equal(t, "https://example.com/1", got.OriginalUrl)
+			shortUrls, err := s.ListByUser(ctx, "owner")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"batch1", "batch2", "batch3"}, shortUrls)
+			found, err := s.FindByTarget(ctx, "owner", "https://example.com/3")
+			require.NoError(t, err)
+			assert.Equal(t, "batch3", found.ShortUrl)
+
+			assert.Empty(t, s.SaveBatch(ctx, nil))
+		})
+	}
+}